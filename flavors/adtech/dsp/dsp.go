@@ -1,17 +1,24 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"io"
 	"log/slog"
+	"math/rand/v2"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"perftest/libs/envvarutil"
@@ -21,10 +28,26 @@ import (
 
 const latencyQueryParam = "latency"
 
+// winNoticeQuery is appended to the /win endpoint in every bid nurl, so the exchange substitutes the auction macros.
+const winNoticeQuery = "?id=${AUCTION_ID}&imp=${AUCTION_IMP_ID}&seat=${AUCTION_SEAT_ID}&price=${AUCTION_PRICE}&cur=${AUCTION_CURRENCY}"
+
+var mWinNoticeTotal = prometheus.NewCounter(prometheus.CounterOpts{Name: "dsp_win_notice_total"})
+var mWinNoticeInvalid = prometheus.NewCounter(prometheus.CounterOpts{Name: "dsp_win_notice_invalid_total"})
+
+func init() {
+	prometheus.MustRegister(mWinNoticeTotal, mWinNoticeInvalid)
+}
+
 // Config is the configuration for the DSP.
 type Config struct {
 	// Latency is the latency to add to the /bid endpoint.
 	Latency time.Duration
+	// MaxPrice is the maximum amount bid above the impression floor.
+	MaxPrice float64
+	// Currency is the currency of the bids. Empty means the OpenRTB default (USD).
+	Currency string
+	// CleartextAddr is the address of an optional listener serving HTTP/1.1 and h2c without TLS.
+	CleartextAddr string
 }
 
 func main() {
@@ -40,6 +63,13 @@ func main() {
 	if config.Latency > 0 {
 		logger.Info("latency from env", slog.Duration("latency", config.Latency))
 	}
+	config.MaxPrice, err = envvarutil.GetFloat("DSP_MAX_PRICE", 5.0)
+	if err != nil {
+		logger.Error("error parsing DSP_MAX_PRICE", slog.Any("error", err))
+		os.Exit(1)
+	}
+	config.Currency = envvarutil.GetString("DSP_CURRENCY", "")
+	config.CleartextAddr = envvarutil.GetString("DSP_CLEARTEXT_ADDR", "")
 
 	rootCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -76,9 +106,63 @@ func main() {
 			time.Sleep(latency)
 		}
 
+		var body io.Reader = r.Body
+		switch r.Header.Get("Content-Encoding") {
+		case "gzip":
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer gz.Close()
+			body = gz
+		case "zstd":
+			zr, err := zstd.NewReader(r.Body, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer zr.Close()
+			body = zr
+		case "br":
+			body = brotli.NewReader(r.Body)
+		}
+
+		var bidRequest openrtb.BidRequest
+		if err := json.NewDecoder(body).Decode(&bidRequest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Bid on every impression with a random price above the floor,
+		// so the exchange auction has something to rank.
+		// When deals are offered, bid on the first one.
+		// The nurl points back at the listener the bid request came in on, TLS or cleartext.
+		scheme := "https"
+		if r.TLS == nil {
+			scheme = "http"
+		}
+		nurl := scheme + "://" + r.Host + "/win" + winNoticeQuery
+		bids := make([]openrtb.Bid, 0, len(bidRequest.Imp))
+		for i, imp := range bidRequest.Imp {
+			bid := openrtb.Bid{
+				ID:    strconv.Itoa(i + 1),
+				ImpID: imp.ID,
+				Price: imp.BidFloor + rand.Float64()*config.MaxPrice,
+				NURL:  nurl,
+			}
+			if imp.PMP != nil && len(imp.PMP.Deals) > 0 {
+				deal := imp.PMP.Deals[0]
+				bid.DealID = deal.ID
+				bid.Price = max(imp.BidFloor, deal.BidFloor) + rand.Float64()*config.MaxPrice
+			}
+			bids = append(bids, bid)
+		}
+
 		bid := &openrtb.BidResponse{
-			ID:      "123",
-			SeatBid: []openrtb.SeatBid{{Bid: []openrtb.Bid{{ID: "123", Price: 1.0, ImpID: "123"}}}},
+			ID:      bidRequest.ID,
+			SeatBid: []openrtb.SeatBid{{Bid: bids}},
+			Cur:     config.Currency,
 		}
 
 		if err := json.NewEncoder(w).Encode(bid); err != nil {
@@ -86,8 +170,33 @@ func main() {
 		}
 	})
 
+	// Win notice endpoint
+	// /win receives the win notices fired by the exchange for the nurl of winning bids.
+	// --
+
+	mux.HandleFunc("/win", func(w http.ResponseWriter, r *http.Request) {
+		mWinNoticeTotal.Inc()
+
+		q := r.URL.Query()
+		if _, err := strconv.ParseFloat(q.Get("price"), 64); err != nil || q.Get("id") == "" || q.Get("imp") == "" {
+			mWinNoticeInvalid.Inc()
+			http.Error(w, "invalid win notice", http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
 	// Starting the HTTP server
 
+	// The cleartext listener serves the same routes to clients testing HTTP/1.1 and h2c without TLS.
+	var cleartext *http.Server
+	if config.CleartextAddr != "" {
+		cleartext = &http.Server{Addr: config.CleartextAddr, Handler: mux, BaseContext: server.BaseContext, Protocols: new(http.Protocols)}
+		cleartext.Protocols.SetHTTP1(true)
+		cleartext.Protocols.SetUnencryptedHTTP2(true)
+	}
+
 	// Graceful shutdown
 	go func() {
 		<-rootCtx.Done()
@@ -99,10 +208,25 @@ func main() {
 		if err := server.Shutdown(c); err != nil {
 			logger.Error("error during shutdown", slog.Any("error", err))
 		}
+		if cleartext != nil {
+			if err := cleartext.Shutdown(c); err != nil {
+				logger.Error("error during cleartext shutdown", slog.Any("error", err))
+			}
+		}
 	}()
 
 	logger.Info("starting")
 
+	if cleartext != nil {
+		logger.Info("starting cleartext listener", slog.String("addr", cleartext.Addr))
+
+		go func() {
+			if err := cleartext.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("cleartext server error", slog.Any("error", err))
+			}
+		}()
+	}
+
 	if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
 		logger.Error("server error", slog.Any("error", err))
 	}
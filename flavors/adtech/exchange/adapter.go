@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"perftest/libs/openrtb"
+)
+
+// Adapters
+// Like the bidder adapters of Prebid Server, an adapter builds the request body a DSP expects
+// out of the auction request. The default OpenRTB adapter applies the DSP transforms; other adapters
+// are registered by name and selected per DSP in dsps.json.
+// The encoding of the body (OpenRTB version, content type and compression) is applied by the fan-out.
+// The OpenRTB version is only sent in the X-Openrtb-Version header: the payload is not converted between versions.
+// Requests left unchanged by the adapter share the body marshalled once per auction, and the body compressed
+// once when the DSP takes the default gzip; an encoding override alone never marshals the request again.
+// --
+
+// Default adapter.
+const AdapterOpenRTB = "openrtb"
+
+// Compressions of the DSP requests.
+const (
+	CompressionNone   = "none"
+	CompressionGzip   = "gzip"
+	CompressionZstd   = "zstd"
+	CompressionBrotli = "brotli"
+)
+
+// HeaderOpenRTBVersion is the header carrying the OpenRTB version of a request.
+const HeaderOpenRTBVersion = "X-Openrtb-Version"
+
+// DSPEncoding represents how the requests of a DSP are encoded.
+// Empty fields keep the defaults: no version header, application/json and gzip.
+// Version only sets the X-Openrtb-Version header; the body is the same OpenRTB request for every version.
+type DSPEncoding struct {
+	Version     string `json:"version,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Compression string `json:"compression,omitempty"`
+}
+
+// DSPTransforms represents the changes made to the requests of a DSP.
+// StripUser removes BidRequest.User and the device identifiers.
+// TMax caps BidRequest.TMax in milliseconds.
+// TagID rewrites Imp.TagID; {tagid}, {imp_id} and {publisher_id} are substituted.
+// Ext fields are set in BidRequest.Ext, replacing fields with the same name.
+type DSPTransforms struct {
+	StripUser bool                       `json:"strip_user,omitempty"`
+	TMax      int                        `json:"tmax,omitempty"`
+	TagID     string                     `json:"tagid,omitempty"`
+	Ext       map[string]json.RawMessage `json:"ext,omitempty"`
+}
+
+// Adapter builds the request body of a DSP. Adapters must not modify the request.
+// A nil body with a nil error sends the shared auction body.
+type Adapter interface {
+	MakeRequest(request *openrtb.BidRequest, inv *Inventory) ([]byte, error)
+}
+
+// AdapterBuilder creates the adapter of a DSP when the DSP cache loads.
+type AdapterBuilder func(dsp *DSP) (Adapter, error)
+
+// adapters holds the registered adapter builders by name.
+var adapters = map[string]AdapterBuilder{
+	AdapterOpenRTB: func(dsp *DSP) (Adapter, error) { return &OpenRTBAdapter{transforms: dsp.Transforms}, nil },
+}
+
+// RegisterAdapter registers an adapter builder. It must be called before the DSP cache loads.
+func RegisterAdapter(name string, builder AdapterBuilder) {
+	adapters[name] = builder
+}
+
+// Compressor creates a writer compressing to w. The writer is closed once the body is written.
+type Compressor func(w io.Writer) (io.WriteCloser, error)
+
+// compressors holds the registered compressors other than none and gzip, which the fan-out handles itself.
+var compressors = map[string]Compressor{}
+
+// RegisterCompressor registers a compressor. It must be called before the DSP cache loads.
+func RegisterCompressor(name string, compressor Compressor) {
+	compressors[name] = compressor
+}
+
+func init() {
+	RegisterCompressor(CompressionZstd, newZstdWriter)
+	RegisterCompressor(CompressionBrotli, newBrotliWriter)
+}
+
+// zstdEncoders pools the zstd encoders, which are costly to create.
+var zstdEncoders = sync.Pool{New: func() any {
+	enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	return enc
+}}
+
+// zstdWriter gives its encoder back to the pool once closed.
+type zstdWriter struct {
+	*zstd.Encoder
+}
+
+func (w zstdWriter) Close() error {
+	err := w.Encoder.Close()
+	zstdEncoders.Put(w.Encoder)
+	return err
+}
+
+// newZstdWriter is the zstd Compressor.
+func newZstdWriter(w io.Writer) (io.WriteCloser, error) {
+	enc := zstdEncoders.Get().(*zstd.Encoder)
+	enc.Reset(w)
+
+	return zstdWriter{enc}, nil
+}
+
+// brotliWriters pools the brotli writers.
+var brotliWriters = sync.Pool{New: func() any { return brotli.NewWriter(nil) }}
+
+// brotliWriter gives its writer back to the pool once closed.
+type brotliWriter struct {
+	*brotli.Writer
+}
+
+func (w brotliWriter) Close() error {
+	err := w.Writer.Close()
+	brotliWriters.Put(w.Writer)
+	return err
+}
+
+// newBrotliWriter is the brotli Compressor.
+func newBrotliWriter(w io.Writer) (io.WriteCloser, error) {
+	bw := brotliWriters.Get().(*brotli.Writer)
+	bw.Reset(w)
+
+	return brotliWriter{bw}, nil
+}
+
+// buildAdapter validates the encoding of the DSP and creates its adapter.
+// It returns a nil Adapter for DSPs served by the shared auction body, whatever their encoding.
+func buildAdapter(dsp *DSP) (Adapter, error) {
+	if dsp.Encoding != nil {
+		switch c := dsp.Encoding.Compression; c {
+		case "", CompressionNone, CompressionGzip:
+		default:
+			if _, ok := compressors[c]; !ok {
+				return nil, fmt.Errorf("adapter: unsupported compression %q", c)
+			}
+		}
+	}
+
+	name := dsp.Adapter
+	if name == "" {
+		name = AdapterOpenRTB
+	}
+	if name == AdapterOpenRTB && dsp.Transforms == nil {
+		return nil, nil
+	}
+
+	builder, ok := adapters[name]
+	if !ok {
+		return nil, fmt.Errorf("adapter: unknown adapter %q", name)
+	}
+
+	return builder(dsp)
+}
+
+// OpenRTBAdapter sends the OpenRTB request with the DSP transforms applied.
+type OpenRTBAdapter struct {
+	transforms *DSPTransforms
+}
+
+// MakeRequest applies the transforms to a copy of the request and marshals it.
+// It returns a nil body when the transforms leave the request unchanged.
+func (a *OpenRTBAdapter) MakeRequest(request *openrtb.BidRequest, inv *Inventory) ([]byte, error) {
+	t := a.transforms
+	if t == nil {
+		return nil, nil
+	}
+
+	out := *request
+	changed := false
+
+	if t.StripUser {
+		changed = changed || out.User != nil
+		out.User = nil
+		if d := out.Device; d != nil && d.IFA+d.DIDSHA1+d.DIDMD5+d.DPIDSHA1+d.DPIDMD5+d.MACSHA1+d.MACMD5 != "" {
+			device := *out.Device
+			device.IFA, device.DIDSHA1, device.DIDMD5 = "", "", ""
+			device.DPIDSHA1, device.DPIDMD5, device.MACSHA1, device.MACMD5 = "", "", "", ""
+			out.Device = &device
+			changed = true
+		}
+	}
+
+	if t.TMax > 0 && (out.TMax == 0 || t.TMax < out.TMax) {
+		out.TMax = t.TMax
+		changed = true
+	}
+
+	if t.TagID != "" {
+		pubID := strconv.Itoa(inv.PublisherID())
+		out.Imp = make([]openrtb.Imp, len(request.Imp))
+		for i, imp := range request.Imp {
+			tagID := strings.NewReplacer("{tagid}", imp.TagID, "{imp_id}", imp.ID, "{publisher_id}", pubID).Replace(t.TagID)
+			changed = changed || tagID != imp.TagID
+			imp.TagID = tagID
+			out.Imp[i] = imp
+		}
+	}
+
+	if len(t.Ext) > 0 {
+		ext := make(map[string]json.RawMessage, len(t.Ext))
+		if len(out.Ext) > 0 {
+			if err := json.Unmarshal(out.Ext, &ext); err != nil {
+				return nil, fmt.Errorf("adapter: invalid request ext: %w", err)
+			}
+		}
+		for k, v := range t.Ext {
+			ext[k] = v
+		}
+
+		b, err := json.Marshal(ext)
+		if err != nil {
+			return nil, err
+		}
+		out.Ext = b
+		changed = true
+	}
+
+	if !changed {
+		return nil, nil
+	}
+
+	return json.Marshal(&out)
+}
+
+// setEncodingHeaders sets the content headers of a DSP request.
+func setEncodingHeaders(h http.Header, encoding *DSPEncoding) {
+	contentType, compression := "application/json", CompressionGzip
+	if encoding != nil {
+		if encoding.ContentType != "" {
+			contentType = encoding.ContentType
+		}
+		if encoding.Compression != "" {
+			compression = encoding.Compression
+		}
+		if encoding.Version != "" {
+			h.Set(HeaderOpenRTBVersion, encoding.Version)
+		}
+	}
+
+	h.Set("Content-Type", contentType)
+	switch compression {
+	case CompressionNone:
+	case CompressionBrotli:
+		h.Set("Content-Encoding", "br")
+	default:
+		h.Set("Content-Encoding", compression)
+	}
+}
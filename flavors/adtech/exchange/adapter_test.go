@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"perftest/libs/openrtb"
+)
+
+func TestBuildAdapter(t *testing.T) {
+	tests := []struct {
+		name        string
+		dsp         *DSP
+		wantAdapter bool
+		wantErr     bool
+	}{
+		{name: "default", dsp: &DSP{}},
+		{name: "transforms", dsp: &DSP{Transforms: &DSPTransforms{StripUser: true}}, wantAdapter: true},
+		{name: "encoding", dsp: &DSP{Encoding: &DSPEncoding{Compression: CompressionNone}}},
+		{name: "named default", dsp: &DSP{Adapter: AdapterOpenRTB}},
+		{name: "unknown adapter", dsp: &DSP{Adapter: "unknown"}, wantErr: true},
+		{name: "zstd", dsp: &DSP{Encoding: &DSPEncoding{Compression: CompressionZstd}}},
+		{name: "brotli", dsp: &DSP{Encoding: &DSPEncoding{Compression: CompressionBrotli}}},
+		{name: "unsupported compression", dsp: &DSP{Encoding: &DSPEncoding{Compression: "lz4"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter, err := buildAdapter(tt.dsp)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; wantErr %v", err, tt.wantErr)
+			}
+			if (adapter != nil) != tt.wantAdapter {
+				t.Errorf("adapter = %v; want adapter %v", adapter, tt.wantAdapter)
+			}
+		})
+	}
+}
+
+func TestOpenRTBAdapter_MakeRequest(t *testing.T) {
+	request := &openrtb.BidRequest{
+		ID:     "req-1",
+		Imp:    []openrtb.Imp{{ID: "1", TagID: "top"}, {ID: "2", TagID: "bottom"}},
+		Device: &openrtb.Device{OS: "android", IFA: "ifa-1"},
+		User:   &openrtb.User{ID: "user-1"},
+		TMax:   200,
+		Ext:    json.RawMessage(`{"a":1,"b":2}`),
+	}
+
+	adapter := &OpenRTBAdapter{transforms: &DSPTransforms{
+		StripUser: true,
+		TMax:      100,
+		TagID:     "{publisher_id}-{tagid}-{imp_id}",
+		Ext:       map[string]json.RawMessage{"b": json.RawMessage(`3`), "c": json.RawMessage(`"x"`)},
+	}}
+
+	b, err := adapter.MakeRequest(request, newTestAppInventory(1, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got openrtb.BidRequest
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.User != nil || got.Device.IFA != "" || got.Device.OS != "android" {
+		t.Errorf("user = %+v, device = %+v; want user and device identifiers stripped", got.User, got.Device)
+	}
+	if got.TMax != 100 {
+		t.Errorf("tmax = %d; want 100", got.TMax)
+	}
+	if got.Imp[0].TagID != "10-top-1" || got.Imp[1].TagID != "10-bottom-2" {
+		t.Errorf("tagids = %q, %q; want 10-top-1, 10-bottom-2", got.Imp[0].TagID, got.Imp[1].TagID)
+	}
+	if string(got.Ext) != `{"a":1,"b":3,"c":"x"}` {
+		t.Errorf("ext = %s", got.Ext)
+	}
+
+	// The auction request is shared by every DSP and must be left untouched.
+	if request.User == nil || request.Device.IFA != "ifa-1" || request.TMax != 200 || request.Imp[0].TagID != "top" ||
+		string(request.Ext) != `{"a":1,"b":2}` {
+		t.Errorf("request modified: %+v", request)
+	}
+}
+
+func TestFanOut_NewRequestSharedBody(t *testing.T) {
+	request := &openrtb.BidRequest{ID: "req-1", Imp: []openrtb.Imp{{ID: "1"}}, TMax: 200}
+	fanout := NewFanOut(FanOutVariant{CompressOnce: true})
+	body, err := fanout.Body(request, newTestAppInventory(1, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		dsp  *DSP
+		want []byte
+	}{
+		{"gzip encoding", &DSP{Encoding: &DSPEncoding{Compression: CompressionGzip, Version: "2.6"}}, body.Compressed},
+		{"no compression", &DSP{Encoding: &DSPEncoding{Compression: CompressionNone}}, body.Raw},
+		{"unchanged by transforms", &DSP{Transforms: &DSPTransforms{TMax: 500, StripUser: true}}, body.Compressed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.dsp.Endpoint = "http://dsp-1:8080/bid"
+			tt.dsp.adapter, _ = buildAdapter(tt.dsp)
+
+			req, err := fanout.NewRequest(context.Background(), tt.dsp, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := io.ReadAll(req.Body)
+			if string(got) != string(tt.want) {
+				t.Errorf("body = %q; want the shared body %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFanOut_NewRequestEncoding(t *testing.T) {
+	request := &openrtb.BidRequest{ID: "req-1", Imp: []openrtb.Imp{{ID: "1"}}}
+	dsp := &DSP{ID: 1, Endpoint: "http://dsp-1:8080/bid", Encoding: &DSPEncoding{
+		Version:     "2.6",
+		ContentType: "application/json; charset=utf-8",
+		Compression: CompressionNone,
+	}}
+	dsp.adapter, _ = buildAdapter(dsp)
+
+	fanout := NewFanOut(FanOutVariant{CompressOnce: true})
+	body, err := fanout.Body(request, newTestAppInventory(1, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := fanout.NewRequest(context.Background(), dsp, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := req.Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q; want none", got)
+	}
+	if got := req.Header.Get("Content-Type"); got != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := req.Header.Get(HeaderOpenRTBVersion); got != "2.6" {
+		t.Errorf("%s = %q; want 2.6", HeaderOpenRTBVersion, got)
+	}
+
+	got, _ := io.ReadAll(req.Body)
+	if string(got) != string(body.Raw) {
+		t.Errorf("body = %s; want %s", got, body.Raw)
+	}
+}
+
+func TestFanOut_NewRequestCompression(t *testing.T) {
+	tests := []struct {
+		compression string
+		encoding    string
+		decode      func(r io.Reader) ([]byte, error)
+	}{
+		{CompressionZstd, "zstd", func(r io.Reader) ([]byte, error) {
+			dec, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			defer dec.Close()
+			return io.ReadAll(dec)
+		}},
+		{CompressionBrotli, "br", func(r io.Reader) ([]byte, error) {
+			return io.ReadAll(brotli.NewReader(r))
+		}},
+	}
+
+	request := &openrtb.BidRequest{ID: "req-1", Imp: []openrtb.Imp{{ID: "1"}}}
+	fanout := NewFanOut(FanOutVariant{CompressOnce: true})
+	body, err := fanout.Body(request, newTestAppInventory(1, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.compression, func(t *testing.T) {
+			dsp := &DSP{ID: 1, Endpoint: "http://dsp-1:8080/bid", Encoding: &DSPEncoding{Compression: tt.compression}}
+			dsp.adapter, _ = buildAdapter(dsp)
+
+			// Writers come from a pool: the second request checks a reused writer.
+			for range 2 {
+				req, err := fanout.NewRequest(context.Background(), dsp, body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := req.Header.Get("Content-Encoding"); got != tt.encoding {
+					t.Errorf("Content-Encoding = %q; want %s", got, tt.encoding)
+				}
+
+				got, err := tt.decode(req.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != string(body.Raw) {
+					t.Errorf("body = %s; want %s", got, body.Raw)
+				}
+			}
+		})
+	}
+}
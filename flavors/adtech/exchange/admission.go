@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Admission control
+// The /ad endpoint admits a bounded number of in-flight requests. Excess requests wait in a bounded
+// queue for up to the queue timeout and are rejected fast with a 503 and Retry-After past that,
+// instead of letting the latency of every request collapse under overload.
+// With CoDel enabled, the queue timeout drops to the CoDel target while the queue has not drained
+// below the target during the last interval, so standing queues are shed quickly.
+// The arrival time of admitted requests is kept in their context, so the time spent queued counts
+// against their tmax.
+// --
+
+// Reasons a request is shed by the admission control.
+const (
+	ShedReasonQueueFull    = "queue_full"
+	ShedReasonQueueTimeout = "queue_timeout"
+	ShedReasonCanceled     = "canceled"
+)
+
+// AdmissionConfig configures the admission control. A MaxInFlight lower than 1 disables it.
+type AdmissionConfig struct {
+	MaxInFlight  int
+	MaxQueue     int
+	QueueTimeout time.Duration
+	CoDel        bool
+	// CoDelTarget is the acceptable queue time; CoDelInterval is the period it is checked over.
+	CoDelTarget   time.Duration
+	CoDelInterval time.Duration
+	RetryAfter    time.Duration
+}
+
+// Admission is the admission control of an endpoint. It is safe for concurrent use.
+type Admission struct {
+	config AdmissionConfig
+	slots  chan struct{}
+
+	mu     sync.Mutex
+	queued int
+	// intervalStart and minWait track the lowest queue time of the current CoDel interval.
+	intervalStart time.Time
+	minWait       time.Duration
+	overloaded    bool
+}
+
+// NewAdmission creates a new admission control.
+func NewAdmission(config AdmissionConfig) *Admission {
+	return &Admission{
+		config:        config,
+		slots:         make(chan struct{}, max(config.MaxInFlight, 0)),
+		intervalStart: time.Now(),
+		minWait:       -1,
+	}
+}
+
+// arrivalKey is the context key of the arrival time of a request.
+type arrivalKey struct{}
+
+// requestArrival returns when the request arrived, before waiting for admission,
+// or now when the request did not go through the admission control.
+func requestArrival(r *http.Request) time.Time {
+	if arrival, ok := r.Context().Value(arrivalKey{}).(time.Time); ok {
+		return arrival
+	}
+
+	return time.Now()
+}
+
+// Wrap returns next behind the admission control.
+func (a *Admission) Wrap(next http.Handler) http.Handler {
+	if a.config.MaxInFlight < 1 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), arrivalKey{}, time.Now()))
+
+		if reason := a.acquire(r); reason != "" {
+			mAdmissionShed.WithLabelValues(reason).Inc()
+			if reason == ShedReasonCanceled {
+				return
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(max(int(a.config.RetryAfter.Seconds()), 1)))
+			http.Error(w, "exchange overloaded", http.StatusServiceUnavailable)
+			return
+		}
+
+		gAdmissionInFlight.Inc()
+		defer func() {
+			gAdmissionInFlight.Dec()
+			<-a.slots
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire takes an in-flight slot, waiting in the queue if needed.
+// A free slot is only taken right away while no request is queued, so new arrivals do not jump the queue.
+// It returns why the request was shed, or "" once it holds a slot.
+func (a *Admission) acquire(r *http.Request) string {
+	a.mu.Lock()
+	if a.queued == 0 {
+		select {
+		case a.slots <- struct{}{}:
+			a.mu.Unlock()
+			a.observeWait(0)
+			return ""
+		default:
+		}
+	}
+	if a.queued >= a.config.MaxQueue {
+		a.mu.Unlock()
+		return ShedReasonQueueFull
+	}
+	a.queued++
+	timeout := a.config.QueueTimeout
+	if a.config.CoDel && a.overloaded {
+		timeout = a.config.CoDelTarget
+	}
+	a.mu.Unlock()
+
+	gAdmissionQueued.Inc()
+	defer func() {
+		a.mu.Lock()
+		a.queued--
+		a.mu.Unlock()
+		gAdmissionQueued.Dec()
+	}()
+
+	start := time.Now()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case a.slots <- struct{}{}:
+		wait := time.Since(start)
+		hAdmissionQueueWait.Observe(wait.Seconds())
+		a.observeWait(wait)
+		return ""
+	case <-timer.C:
+		a.observeWait(timeout)
+		return ShedReasonQueueTimeout
+	case <-r.Context().Done():
+		return ShedReasonCanceled
+	}
+}
+
+// observeWait records a queue time for the CoDel check.
+// At the end of each interval, the queue is overloaded if no request waited less than the target.
+func (a *Admission) observeWait(wait time.Duration) {
+	if !a.config.CoDel {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.minWait < 0 || wait < a.minWait {
+		a.minWait = wait
+	}
+
+	now := time.Now()
+	if now.Sub(a.intervalStart) < a.config.CoDelInterval {
+		return
+	}
+
+	a.overloaded = a.minWait > a.config.CoDelTarget
+	a.intervalStart = now
+	a.minWait = -1
+}
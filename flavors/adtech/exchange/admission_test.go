@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdmission_Wrap(t *testing.T) {
+	entered, release := make(chan struct{}, 2), make(chan struct{})
+	handler := NewAdmission(AdmissionConfig{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 50 * time.Millisecond, RetryAfter: 2 * time.Second}).
+		Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			entered <- struct{}{}
+			<-release
+		}))
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ad", nil))
+		return w
+	}
+
+	var wg sync.WaitGroup
+	wg.Go(func() { serve() })
+	<-entered
+
+	// The second request waits in the queue, so the third one finds it full.
+	queued := make(chan *httptest.ResponseRecorder)
+	go func() { queued <- serve() }()
+	time.Sleep(10 * time.Millisecond)
+
+	full := serve()
+	if full.Code != http.StatusServiceUnavailable || full.Header().Get("Retry-After") != "2" {
+		t.Errorf("queue full = %d, Retry-After %q; want 503, 2", full.Code, full.Header().Get("Retry-After"))
+	}
+
+	if w := <-queued; w.Code != http.StatusServiceUnavailable {
+		t.Errorf("queue timeout = %d; want 503", w.Code)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if w := serve(); w.Code != http.StatusOK {
+		t.Errorf("after release = %d; want 200", w.Code)
+	}
+}
+
+func TestAdmission_NoQueueJumping(t *testing.T) {
+	a := NewAdmission(AdmissionConfig{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: time.Second})
+	// A queued request has not taken the slot freed for it yet: a new arrival must queue behind it.
+	a.queued = 1
+
+	if reason := a.acquire(httptest.NewRequest(http.MethodPost, "/ad", nil)); reason != ShedReasonQueueFull {
+		t.Errorf("new arrival = %q; want %q, not the free slot", reason, ShedReasonQueueFull)
+	}
+	if len(a.slots) != 0 {
+		t.Error("the free slot was taken ahead of the queued request")
+	}
+}
+
+func TestAdmission_CoDel(t *testing.T) {
+	a := NewAdmission(AdmissionConfig{MaxInFlight: 1, CoDel: true, CoDelTarget: 5 * time.Millisecond, CoDelInterval: time.Millisecond})
+
+	time.Sleep(2 * time.Millisecond)
+	a.observeWait(20 * time.Millisecond)
+	if !a.overloaded {
+		t.Fatal("queue above the target for an interval should be overloaded")
+	}
+
+	a.observeWait(0)
+	time.Sleep(2 * time.Millisecond)
+	a.observeWait(20 * time.Millisecond)
+	if a.overloaded {
+		t.Error("queue drained during the interval should not be overloaded")
+	}
+}
+
+func TestAdmission_Arrival(t *testing.T) {
+	waited := make(chan time.Duration, 2)
+	release := make(chan struct{})
+	handler := NewAdmission(AdmissionConfig{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: time.Second}).
+		Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			waited <- time.Since(requestArrival(r))
+			<-release
+		}))
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Go(func() { handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/ad", nil)) })
+	}
+	<-waited
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	if d := <-waited; d < 20*time.Millisecond {
+		t.Errorf("queued request arrival = %v ago; want the time waited for admission", d)
+	}
+	wg.Wait()
+}
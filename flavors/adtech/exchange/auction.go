@@ -0,0 +1,342 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+
+	"perftest/libs/openrtb"
+)
+
+// Auction
+// The auction runs after the DSP fan-out. It walks every bid received from every DSP,
+// picks a winner per impression and builds a single merged bid response with the clearing prices.
+// --
+
+// Auction types as defined by the OpenRTB spec (BidRequest.at).
+const (
+	AuctionTypeFirstPrice  = 1
+	AuctionTypeSecondPrice = 2
+)
+
+// Reasons a bid is rejected before the auction.
+const (
+	RejectReasonInvalidImpID    = "invalid_imp_id"
+	RejectReasonBelowFloor      = "below_floor"
+	RejectReasonUnknownCurrency = "unknown_currency"
+	RejectReasonBlockedAdomain  = "blocked_adomain"
+	RejectReasonBlockedCategory = "blocked_category"
+	RejectReasonBlockedAttr     = "blocked_attr"
+	RejectReasonUnknownSeat     = "unknown_seat"
+	RejectReasonSeatNotAllowed  = "seat_not_allowed"
+	RejectReasonUnknownDeal     = "unknown_deal"
+	RejectReasonPrivateAuction  = "private_auction"
+)
+
+// AuctionConfig holds the exchange wide auction settings.
+type AuctionConfig struct {
+	// Currency is the exchange currency. Bids and floors are normalised to it before ranking.
+	Currency string
+	// SecondPriceIncrement is the amount added to the runner-up price in second price auctions.
+	SecondPriceIncrement float64
+}
+
+// Candidate represents a bid taking part in the auction together with its origin.
+type Candidate struct {
+	DSPID int
+	Seat  string
+	Bid   *openrtb.Bid
+	// Deal is the deal the bid was placed on, or nil for open market bids.
+	Deal *openrtb.Deal
+	// Price is the bid price in the exchange currency, used to rank the bid.
+	Price float64
+}
+
+// Winner represents the winning bid of an impression.
+type Winner struct {
+	DSPID int
+	Seat  string
+	// Bid is the bid as sent in the merged response, carrying the clearing price.
+	Bid openrtb.Bid
+	// Currency is the currency of the clearing price.
+	Currency string
+	// MinToWin is the minimum bid that would have won: the highest of the runner-up price and the floor,
+	// in the currency of the clearing price.
+	MinToWin float64
+	// MBR is the market bid ratio: the clearing price over the bid price.
+	MBR float64
+}
+
+// MacroValues returns the values substituted for the auction macros of the winning bid.
+func (w *Winner) MacroValues(auctionID string) openrtb.MacroValues {
+	return openrtb.MacroValues{
+		AuctionID: auctionID,
+		BidID:     w.Bid.ID,
+		ImpID:     w.Bid.ImpID,
+		SeatID:    w.Seat,
+		AdID:      w.Bid.AdID,
+		Price:     w.Bid.Price,
+		Currency:  w.Currency,
+		MBR:       w.MBR,
+		MinToWin:  w.MinToWin,
+	}
+}
+
+// Macro expanders for the winning bids. URLs get their substituted values query-escaped; markup does not.
+var (
+	admMacros = &openrtb.MacroExpander{}
+	urlMacros = &openrtb.MacroExpander{URLEscape: true}
+)
+
+// Auction holds the state of a single auction.
+// It is not safe for concurrent use; the /ad handler feeds it from a single goroutine.
+type Auction struct {
+	request    *openrtb.BidRequest
+	config     AuctionConfig
+	rates      *CurrencyRates
+	dsps       *DSPs
+	imps       map[string]*openrtb.Imp
+	candidates map[string][]Candidate
+	winners    []Winner
+	bids       int
+	rejections map[string]int
+}
+
+// NewAuction creates a new auction for the given bid request.
+// Impression floors are expected to be already normalised to the exchange currency.
+// dsps is used to check that bids come from seats owned by the DSP; a nil dsps skips the check.
+func NewAuction(request *openrtb.BidRequest, config AuctionConfig, rates *CurrencyRates, dsps *DSPs) *Auction {
+	imps := make(map[string]*openrtb.Imp, len(request.Imp))
+	for i := range request.Imp {
+		imps[request.Imp[i].ID] = &request.Imp[i]
+	}
+
+	return &Auction{
+		request:    request,
+		config:     config,
+		rates:      rates,
+		dsps:       dsps,
+		imps:       imps,
+		candidates: make(map[string][]Candidate, len(request.Imp)),
+		rejections: make(map[string]int),
+	}
+}
+
+// Add adds every bid from a DSP response to the auction.
+// Bids are grouped by Bid.ImpID; bids for impressions not offered in the request are rejected.
+func (a *Auction) Add(out *Out) {
+	dspIDStr := strconv.Itoa(out.DSPID)
+	cur := out.BidResponse.Cur
+
+	var dsp *DSP
+	if a.dsps != nil {
+		dsp = a.dsps.ByID[out.DSPID]
+	}
+
+	for i := range out.BidResponse.SeatBid {
+		seatBid := &out.BidResponse.SeatBid[i]
+		seat := seatBid.Seat
+		if seat == "" {
+			seat = defaultSeat(dsp, out.DSPID)
+		}
+		seatOwned := dsp == nil || slices.Contains(dsp.Seats, seat)
+
+		for j := range seatBid.Bid {
+			bid := &seatBid.Bid[j]
+
+			mAuctionBidTotal.WithLabelValues(dspIDStr).Inc()
+			a.bids++
+
+			imp, ok := a.imps[bid.ImpID]
+			if !ok {
+				a.reject(dspIDStr, RejectReasonInvalidImpID)
+				continue
+			}
+
+			price, ok := a.rates.Convert(bid.Price, cur, a.config.Currency)
+			if !ok {
+				a.reject(dspIDStr, RejectReasonUnknownCurrency)
+				continue
+			}
+
+			var deal *openrtb.Deal
+			if bid.DealID != "" {
+				if deal = findDeal(imp, bid.DealID); deal == nil {
+					a.reject(dspIDStr, RejectReasonUnknownDeal)
+					continue
+				}
+			} else if imp.PMP != nil && imp.PMP.PrivateAuction == 1 {
+				a.reject(dspIDStr, RejectReasonPrivateAuction)
+				continue
+			}
+
+			if price < bidFloor(imp, deal) {
+				a.reject(dspIDStr, RejectReasonBelowFloor)
+				continue
+			}
+
+			if reason := blockReason(a.request, imp, bid); reason != "" {
+				a.reject(dspIDStr, reason)
+				continue
+			}
+
+			if !seatOwned {
+				a.reject(dspIDStr, RejectReasonUnknownSeat)
+				continue
+			}
+
+			if !bidSeatAllowed(a.request, deal, seat) {
+				a.reject(dspIDStr, RejectReasonSeatNotAllowed)
+				continue
+			}
+
+			a.candidates[bid.ImpID] = append(a.candidates[bid.ImpID], Candidate{
+				DSPID: out.DSPID,
+				Seat:  seat,
+				Bid:   bid,
+				Deal:  deal,
+				Price: price,
+			})
+		}
+	}
+}
+
+// reject records a bid rejected before the auction.
+func (a *Auction) reject(dspIDStr, reason string) {
+	mAuctionBidRejected.WithLabelValues(dspIDStr, reason).Inc()
+	a.rejections[reason]++
+}
+
+// Run picks a winner per impression and returns the merged bid response.
+// Winning bids carry the clearing price, with the auction macros substituted in Adm and IURL.
+// Impressions without bids are left out of the response.
+func (a *Auction) Run() openrtb.BidResponse {
+	cur := a.responseCurrency()
+	response := openrtb.BidResponse{ID: a.request.ID, Cur: cur}
+	seats := make(map[string]int)
+
+	for i := range a.request.Imp {
+		imp := &a.request.Imp[i]
+		winner, price, minToWin, ok := a.resolve(imp, a.candidates[imp.ID])
+		if !ok {
+			continue
+		}
+
+		mAuctionWinTotal.WithLabelValues(strconv.Itoa(winner.DSPID)).Inc()
+		if winner.Deal != nil {
+			mAuctionDealWinTotal.WithLabelValues(strconv.Itoa(winner.DSPID)).Inc()
+		}
+
+		bid := *winner.Bid
+		bid.Price, _ = a.rates.Convert(price, a.config.Currency, cur)
+
+		idx, ok := seats[winner.Seat]
+		if !ok {
+			idx = len(response.SeatBid)
+			seats[winner.Seat] = idx
+			response.SeatBid = append(response.SeatBid, openrtb.SeatBid{Seat: winner.Seat})
+		}
+
+		w := Winner{DSPID: winner.DSPID, Seat: winner.Seat, Bid: bid, Currency: cur}
+		w.MinToWin, _ = a.rates.Convert(minToWin, a.config.Currency, cur)
+		if winner.Price > 0 {
+			w.MBR = price / winner.Price
+		}
+		values := w.MacroValues(a.request.ID)
+		w.Bid.Adm = admMacros.Expand(w.Bid.Adm, &values)
+		w.Bid.IURL = urlMacros.Expand(w.Bid.IURL, &values)
+
+		response.SeatBid[idx].Bid = append(response.SeatBid[idx].Bid, w.Bid)
+		a.winners = append(a.winners, w)
+	}
+
+	return response
+}
+
+// Winners returns the winners picked by Run, in impression order.
+func (a *Auction) Winners() []Winner {
+	return a.winners
+}
+
+// resolve returns the winning candidate, its clearing price and the minimum bid to win,
+// the highest of the runner-up price and the floor.
+// Ties are broken by arrival order: the first bid received wins.
+// In second price auctions the clearing price is the highest of the runner-up price and the floor, plus the increment,
+// capped at the winner price. A winning deal bid is cleared with the auction type of its deal, when set;
+// fixed price deals clear at the deal floor.
+func (a *Auction) resolve(imp *openrtb.Imp, candidates []Candidate) (*Candidate, float64, float64, bool) {
+	if len(candidates) == 0 {
+		return nil, 0, 0, false
+	}
+
+	winner := &candidates[0]
+	var runnerUp *Candidate
+	for i := 1; i < len(candidates); i++ {
+		c := &candidates[i]
+		if c.Price > winner.Price {
+			runnerUp = winner
+			winner = c
+		} else if runnerUp == nil || c.Price > runnerUp.Price {
+			runnerUp = c
+		}
+	}
+
+	base := bidFloor(imp, winner.Deal)
+	if runnerUp != nil && runnerUp.Price > base {
+		base = runnerUp.Price
+	}
+
+	at := a.auctionType()
+	if winner.Deal != nil && winner.Deal.AT != 0 {
+		at = winner.Deal.AT
+	}
+
+	switch {
+	case at == AuctionTypeFirstPrice:
+		return winner, winner.Price, base, true
+	case at == AuctionTypeFixedPrice:
+		return winner, bidFloor(imp, winner.Deal), base, true
+	case base == 0:
+		return winner, winner.Price, base, true
+	}
+
+	return winner, min(base+a.config.SecondPriceIncrement, winner.Price), base, true
+}
+
+// responseCurrency returns the currency of the merged bid response.
+// It is the exchange currency, unless the request restricts currencies to others known by the exchange.
+func (a *Auction) responseCurrency() string {
+	if len(a.request.Cur) == 0 {
+		return a.config.Currency
+	}
+
+	for _, cur := range a.request.Cur {
+		if cur == a.config.Currency {
+			return cur
+		}
+	}
+	for _, cur := range a.request.Cur {
+		if _, ok := a.rates.Convert(0, a.config.Currency, cur); ok {
+			return cur
+		}
+	}
+
+	return a.config.Currency
+}
+
+// auctionType returns the auction type of the request.
+// The spec defines second price as the default when "at" is absent.
+func (a *Auction) auctionType() int {
+	if a.request.AuctionType == AuctionTypeFirstPrice {
+		return AuctionTypeFirstPrice
+	}
+	return AuctionTypeSecondPrice
+}
+
+// bidFloor returns the floor a bid must meet: the deal floor for deal bids, or the impression floor.
+func bidFloor(imp *openrtb.Imp, deal *openrtb.Deal) float64 {
+	if deal != nil && deal.BidFloor > 0 {
+		return deal.BidFloor
+	}
+
+	return imp.BidFloor
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"testing"
+
+	"perftest/libs/openrtb"
+)
+
+func newTestOut(dspID int, seat string, bids ...openrtb.Bid) *Out {
+	return &Out{
+		DSPID:       dspID,
+		BidResponse: openrtb.BidResponse{SeatBid: []openrtb.SeatBid{{Seat: seat, Bid: bids}}},
+	}
+}
+
+func TestAuction_FirstPrice(t *testing.T) {
+	req := &openrtb.BidRequest{ID: "r1", AuctionType: AuctionTypeFirstPrice, Imp: []openrtb.Imp{{ID: "1"}}}
+	a := NewAuction(req, AuctionConfig{Currency: "USD", SecondPriceIncrement: 0.01}, nil, nil)
+	a.Add(newTestOut(1, "a", openrtb.Bid{ID: "b1", ImpID: "1", Price: 1.5}))
+	a.Add(newTestOut(2, "b", openrtb.Bid{ID: "b2", ImpID: "1", Price: 2.5}))
+
+	res := a.Run()
+	if res.ID != "r1" {
+		t.Errorf("ID = %q; want r1", res.ID)
+	}
+	if len(res.SeatBid) != 1 || res.SeatBid[0].Seat != "b" {
+		t.Fatalf("SeatBid = %+v; want single seat b", res.SeatBid)
+	}
+	if got := res.SeatBid[0].Bid[0].Price; got != 2.5 {
+		t.Errorf("price = %v; want 2.5", got)
+	}
+}
+
+func TestAuction_SecondPrice(t *testing.T) {
+	req := &openrtb.BidRequest{ID: "r1", AuctionType: AuctionTypeSecondPrice, Imp: []openrtb.Imp{{ID: "1"}}}
+	a := NewAuction(req, AuctionConfig{Currency: "USD", SecondPriceIncrement: 0.01}, nil, nil)
+	a.Add(newTestOut(1, "a", openrtb.Bid{ID: "b1", ImpID: "1", Price: 1.5}))
+	a.Add(newTestOut(2, "b", openrtb.Bid{ID: "b2", ImpID: "1", Price: 2.5}))
+	a.Add(newTestOut(3, "c", openrtb.Bid{ID: "b3", ImpID: "1", Price: 0.5}))
+
+	res := a.Run()
+	if len(res.SeatBid) != 1 || res.SeatBid[0].Bid[0].ID != "b2" {
+		t.Fatalf("SeatBid = %+v; want winner b2", res.SeatBid)
+	}
+	if got := res.SeatBid[0].Bid[0].Price; got != 1.51 {
+		t.Errorf("price = %v; want 1.51", got)
+	}
+}
+
+func TestAuction_MacroValues(t *testing.T) {
+	req := &openrtb.BidRequest{ID: "r1", Imp: []openrtb.Imp{{ID: "1", BidFloor: 0.5}}}
+	a := NewAuction(req, AuctionConfig{Currency: "USD", SecondPriceIncrement: 1}, nil, nil)
+	a.Add(newTestOut(1, "a", openrtb.Bid{ID: "b1", ImpID: "1", Price: 1}))
+	a.Add(newTestOut(2, "b", openrtb.Bid{ID: "b2", ImpID: "1", Price: 4, Adm: "${AUCTION_PRICE}|${AUCTION_MIN_TO_WIN}|${AUCTION_MBR}|${AUCTION_LOSS}"}))
+
+	res := a.Run()
+	if got := res.SeatBid[0].Bid[0].Adm; got != "2|1|0.5|0" {
+		t.Errorf("Adm = %q; want 2|1|0.5|0", got)
+	}
+}
+
+func TestAuction_SecondPriceCappedAtWinnerPrice(t *testing.T) {
+	req := &openrtb.BidRequest{ID: "r1", Imp: []openrtb.Imp{{ID: "1"}}}
+	a := NewAuction(req, AuctionConfig{Currency: "USD", SecondPriceIncrement: 0.5}, nil, nil)
+	a.Add(newTestOut(1, "a", openrtb.Bid{ID: "b1", ImpID: "1", Price: 2.0}))
+	a.Add(newTestOut(2, "b", openrtb.Bid{ID: "b2", ImpID: "1", Price: 2.2}))
+
+	res := a.Run()
+	if got := res.SeatBid[0].Bid[0].Price; got != 2.2 {
+		t.Errorf("price = %v; want 2.2", got)
+	}
+}
+
+func TestAuction_WinnerPerImp(t *testing.T) {
+	req := &openrtb.BidRequest{ID: "r1", AuctionType: AuctionTypeFirstPrice, Imp: []openrtb.Imp{{ID: "1"}, {ID: "2"}, {ID: "3"}}}
+	a := NewAuction(req, AuctionConfig{Currency: "USD", SecondPriceIncrement: 0.01}, nil, nil)
+	a.Add(newTestOut(1, "a",
+		openrtb.Bid{ID: "a1", ImpID: "1", Price: 3},
+		openrtb.Bid{ID: "a2", ImpID: "2", Price: 1},
+	))
+	a.Add(newTestOut(2, "",
+		openrtb.Bid{ID: "b1", ImpID: "1", Price: 2},
+		openrtb.Bid{ID: "b2", ImpID: "2", Price: 4},
+		openrtb.Bid{ID: "b9", ImpID: "9", Price: 10},
+	))
+
+	res := a.Run()
+	winners := make(map[string]string)
+	for _, sb := range res.SeatBid {
+		for _, b := range sb.Bid {
+			winners[b.ImpID] = sb.Seat + "/" + b.ID
+		}
+	}
+	if len(winners) != 2 {
+		t.Fatalf("winners = %v; want 2 impressions", winners)
+	}
+	if winners["1"] != "a/a1" {
+		t.Errorf("imp 1 winner = %q; want a/a1", winners["1"])
+	}
+	if winners["2"] != "2/b2" {
+		t.Errorf("imp 2 winner = %q; want 2/b2", winners["2"])
+	}
+}
+
+func TestAuction_NoBids(t *testing.T) {
+	req := &openrtb.BidRequest{ID: "r1", Imp: []openrtb.Imp{{ID: "1"}}}
+	res := NewAuction(req, AuctionConfig{Currency: "USD", SecondPriceIncrement: 0.01}, nil, nil).Run()
+	if len(res.SeatBid) != 0 {
+		t.Errorf("SeatBid = %+v; want empty", res.SeatBid)
+	}
+}
+
+func TestAuction_RejectsBidsBelowFloor(t *testing.T) {
+	req := &openrtb.BidRequest{ID: "r1", AuctionType: AuctionTypeFirstPrice, Imp: []openrtb.Imp{{ID: "1", BidFloor: 2}}}
+	a := NewAuction(req, AuctionConfig{Currency: "USD", SecondPriceIncrement: 0.01}, nil, nil)
+	a.Add(newTestOut(1, "a", openrtb.Bid{ID: "b1", ImpID: "1", Price: 1.99}))
+
+	res := a.Run()
+	if len(res.SeatBid) != 0 {
+		t.Errorf("SeatBid = %+v; want empty", res.SeatBid)
+	}
+}
+
+func TestAuction_SecondPriceSingleBidPaysFloor(t *testing.T) {
+	req := &openrtb.BidRequest{ID: "r1", AuctionType: AuctionTypeSecondPrice, Imp: []openrtb.Imp{{ID: "1", BidFloor: 1}}}
+	a := NewAuction(req, AuctionConfig{Currency: "USD", SecondPriceIncrement: 0.25}, nil, nil)
+	a.Add(newTestOut(1, "a", openrtb.Bid{ID: "b1", ImpID: "1", Price: 3}))
+
+	res := a.Run()
+	if got := res.SeatBid[0].Bid[0].Price; got != 1.25 {
+		t.Errorf("price = %v; want 1.25", got)
+	}
+}
+
+func TestAuction_NormalisesBidCurrency(t *testing.T) {
+	rates := &CurrencyRates{Base: "USD", Rates: map[string]float64{"USD": 1, "EUR": 0.5}}
+	req := &openrtb.BidRequest{ID: "r1", AuctionType: AuctionTypeFirstPrice, Imp: []openrtb.Imp{{ID: "1"}}}
+	a := NewAuction(req, AuctionConfig{Currency: "USD"}, rates, nil)
+
+	eur := newTestOut(1, "a", openrtb.Bid{ID: "b1", ImpID: "1", Price: 3})
+	eur.BidResponse.Cur = "EUR"
+	a.Add(eur)
+	a.Add(newTestOut(2, "b", openrtb.Bid{ID: "b2", ImpID: "1", Price: 5}))
+	gbp := newTestOut(3, "c", openrtb.Bid{ID: "b3", ImpID: "1", Price: 100})
+	gbp.BidResponse.Cur = "GBP"
+	a.Add(gbp)
+
+	res := a.Run()
+	if res.Cur != "USD" {
+		t.Errorf("Cur = %q; want USD", res.Cur)
+	}
+	if got := res.SeatBid[0].Bid[0]; got.ID != "b1" || got.Price != 6 {
+		t.Errorf("winner = %s at %v; want b1 at 6", got.ID, got.Price)
+	}
+}
+
+func TestAuction_ValidatesSeats(t *testing.T) {
+	dsps := &DSPs{ByID: map[int]*DSP{
+		1: {ID: 1, Seats: []string{"a"}},
+		2: {ID: 2, Seats: []string{"b"}},
+		3: {ID: 3, Seats: []string{"c"}},
+	}}
+	req := &openrtb.BidRequest{
+		ID:          "r1",
+		AuctionType: AuctionTypeFirstPrice,
+		BSeat:       []string{"b"},
+		Imp:         []openrtb.Imp{{ID: "1"}},
+	}
+	a := NewAuction(req, AuctionConfig{Currency: "USD"}, nil, dsps)
+	a.Add(newTestOut(1, "x", openrtb.Bid{ID: "b1", ImpID: "1", Price: 9}))
+	a.Add(newTestOut(2, "b", openrtb.Bid{ID: "b2", ImpID: "1", Price: 8}))
+	a.Add(newTestOut(3, "", openrtb.Bid{ID: "b3", ImpID: "1", Price: 1}))
+
+	res := a.Run()
+	if len(res.SeatBid) != 1 || res.SeatBid[0].Seat != "c" || res.SeatBid[0].Bid[0].ID != "b3" {
+		t.Errorf("SeatBid = %+v; want b3 from seat c", res.SeatBid)
+	}
+}
+
+func TestAuction_Deals(t *testing.T) {
+	req := &openrtb.BidRequest{ID: "r1", AuctionType: AuctionTypeFirstPrice, Imp: []openrtb.Imp{{
+		ID:       "1",
+		BidFloor: 0.5,
+		PMP: &openrtb.PMP{
+			PrivateAuction: 1,
+			Deals:          []openrtb.Deal{{ID: "d1", BidFloor: 2, AT: AuctionTypeFixedPrice}},
+		},
+	}}}
+	a := NewAuction(req, AuctionConfig{Currency: "USD"}, nil, nil)
+	a.Add(newTestOut(1, "a", openrtb.Bid{ID: "open", ImpID: "1", Price: 10}))
+	a.Add(newTestOut(2, "b", openrtb.Bid{ID: "unknown", ImpID: "1", Price: 10, DealID: "d9"}))
+	a.Add(newTestOut(3, "c", openrtb.Bid{ID: "low", ImpID: "1", Price: 1.5, DealID: "d1"}))
+	a.Add(newTestOut(4, "d", openrtb.Bid{ID: "deal", ImpID: "1", Price: 3, DealID: "d1"}))
+
+	res := a.Run()
+	if len(res.SeatBid) != 1 {
+		t.Fatalf("SeatBid = %+v; want a single winner", res.SeatBid)
+	}
+	if got := res.SeatBid[0].Bid[0]; got.ID != "deal" || got.Price != 2 {
+		t.Errorf("winner = %s at %v; want deal at the fixed price 2", got.ID, got.Price)
+	}
+}
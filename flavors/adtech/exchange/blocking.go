@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"perftest/libs/intern"
+	"perftest/libs/openrtb"
+)
+
+// Blocking
+// Bids are dropped when their advertiser domains (badv), categories (bcat) or creative attributes (battr)
+// hit the block lists of the request. Per-publisher block lists are merged into the request before the fan-out,
+// so DSPs can honour them too and the auction only has to look at the request.
+// --
+
+// Blocklist represents the block lists of a publisher.
+type Blocklist struct {
+	PublisherID int      `json:"publisher_id"`
+	BAdv        []string `json:"badv,omitempty"`
+	BCategory   []string `json:"bcat,omitempty"`
+	BAttr       []int    `json:"battr,omitempty"`
+}
+
+// Blocklists holds a map of block lists by publisher ID for quick lookup.
+type Blocklists struct {
+	ByPublisher map[int]*Blocklist
+}
+
+// Apply merges the publisher block lists into the request.
+// Advertiser domains and categories go to the request level; creative attributes go to every impression.
+// A nil Blocklists leaves the request untouched.
+func (b *Blocklists) Apply(inv *Inventory, request *openrtb.BidRequest) {
+	if b == nil || inv == nil || inv.Publisher == nil {
+		return
+	}
+
+	list := b.ByPublisher[inv.Publisher.ID]
+	if list == nil {
+		return
+	}
+
+	request.BAdv = mergeUnique(request.BAdv, list.BAdv)
+	request.BCategory = mergeUnique(request.BCategory, list.BCategory)
+
+	if len(list.BAttr) == 0 {
+		return
+	}
+
+	for i := range request.Imp {
+		imp := &request.Imp[i]
+		if imp.Banner != nil {
+			imp.Banner.BAttr = mergeUnique(imp.Banner.BAttr, list.BAttr)
+		}
+		if imp.Video != nil {
+			imp.Video.BAttr = mergeUnique(imp.Video.BAttr, list.BAttr)
+		}
+		if imp.Audio != nil {
+			imp.Audio.BAttr = mergeUnique(imp.Audio.BAttr, list.BAttr)
+		}
+	}
+}
+
+// blockReason returns the reason the bid hits the block lists of the request and impression,
+// or an empty string when the bid is allowed.
+// Block lists are usually short, so linear scans are cheaper than building sets per request.
+func blockReason(request *openrtb.BidRequest, imp *openrtb.Imp, bid *openrtb.Bid) string {
+	if len(request.BAdv) > 0 {
+		for _, domain := range bid.Adomain {
+			if adomainBlocked(request.BAdv, domain) {
+				return RejectReasonBlockedAdomain
+			}
+		}
+	}
+
+	if len(request.BCategory) > 0 {
+		for _, cat := range bid.Cat {
+			if categoryBlocked(request.BCategory, cat) {
+				return RejectReasonBlockedCategory
+			}
+		}
+	}
+
+	if len(bid.Attr) > 0 {
+		for _, attr := range bid.Attr {
+			if attrBlocked(imp, attr) {
+				return RejectReasonBlockedAttr
+			}
+		}
+	}
+
+	return ""
+}
+
+// adomainBlocked reports whether the domain, or any of its parent domains, is blocked.
+// Matching is case-insensitive, so "ads.Example.com" is blocked by "example.com".
+func adomainBlocked(blocked []string, domain string) bool {
+	for {
+		for _, b := range blocked {
+			if strings.EqualFold(b, domain) {
+				return true
+			}
+		}
+
+		dot := strings.IndexByte(domain, '.')
+		if dot == -1 {
+			return false
+		}
+		domain = domain[dot+1:]
+	}
+}
+
+// categoryBlocked reports whether the IAB category, or its tier-1 parent, is blocked.
+// Blocking "IAB7" blocks "IAB7-39" too.
+func categoryBlocked(blocked []string, cat string) bool {
+	parent := cat
+	if dash := strings.IndexByte(cat, '-'); dash != -1 {
+		parent = cat[:dash]
+	}
+
+	for _, b := range blocked {
+		if b == cat || b == parent {
+			return true
+		}
+	}
+
+	return false
+}
+
+// attrBlocked reports whether the creative attribute is blocked by any media type of the impression.
+func attrBlocked(imp *openrtb.Imp, attr int) bool {
+	if imp.Banner != nil && slices.Contains(imp.Banner.BAttr, attr) {
+		return true
+	}
+	if imp.Video != nil && slices.Contains(imp.Video.BAttr, attr) {
+		return true
+	}
+	if imp.Audio != nil && slices.Contains(imp.Audio.BAttr, attr) {
+		return true
+	}
+
+	return false
+}
+
+// mergeUnique appends the values of src missing from dst.
+func mergeUnique[T comparable](dst, src []T) []T {
+	for _, v := range src {
+		if !slices.Contains(dst, v) {
+			dst = append(dst, v)
+		}
+	}
+
+	return dst
+}
+
+// CacheLoadBlocklists loads the per-publisher block lists from the given path.
+func CacheLoadBlocklists(path string, useIntern bool) CacheLoadFunc {
+	return func(state *State, logger *slog.Logger) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		var decoded []Blocklist
+		if err = json.NewDecoder(f).Decode(&decoded); err != nil {
+			return err
+		}
+
+		lists := make(map[int]*Blocklist, len(decoded))
+		for i := range decoded {
+			list := &decoded[i]
+			if useIntern {
+				for j := range list.BAdv {
+					list.BAdv[j] = intern.InternString(list.BAdv[j])
+				}
+				for j := range list.BCategory {
+					list.BCategory[j] = intern.InternString(list.BCategory[j])
+				}
+			}
+			lists[list.PublisherID] = list
+		}
+
+		state.Blocklists.Store(&Blocklists{ByPublisher: lists})
+
+		logger.Info("cache: loaded blocklists", slog.Int("count", len(decoded)))
+
+		return nil
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"perftest/libs/openrtb"
+)
+
+func TestBlockReason(t *testing.T) {
+	req := &openrtb.BidRequest{
+		BAdv:      []string{"blocked.com"},
+		BCategory: []string{"IAB7", "IAB25-3"},
+	}
+	imp := &openrtb.Imp{Banner: &openrtb.Banner{BAttr: []int{3}}}
+
+	tests := []struct {
+		name string
+		bid  openrtb.Bid
+		want string
+	}{
+		{"allowed", openrtb.Bid{Adomain: []string{"ok.com"}, Cat: []string{"IAB1"}, Attr: []int{1}}, ""},
+		{"exact domain", openrtb.Bid{Adomain: []string{"blocked.com"}}, RejectReasonBlockedAdomain},
+		{"subdomain", openrtb.Bid{Adomain: []string{"ads.Blocked.com"}}, RejectReasonBlockedAdomain},
+		{"suffix only", openrtb.Bid{Adomain: []string{"notblocked.com"}}, ""},
+		{"tier-1 category", openrtb.Bid{Cat: []string{"IAB7-39"}}, RejectReasonBlockedCategory},
+		{"exact category", openrtb.Bid{Cat: []string{"IAB25-3"}}, RejectReasonBlockedCategory},
+		{"sibling category", openrtb.Bid{Cat: []string{"IAB25-2"}}, ""},
+		{"attribute", openrtb.Bid{Attr: []int{2, 3}}, RejectReasonBlockedAttr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := blockReason(req, imp, &tt.bid); got != tt.want {
+				t.Errorf("blockReason() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlocklists_Apply(t *testing.T) {
+	lists := &Blocklists{ByPublisher: map[int]*Blocklist{
+		10: {PublisherID: 10, BAdv: []string{"a.com", "b.com"}, BCategory: []string{"IAB25"}, BAttr: []int{1}},
+	}}
+	req := &openrtb.BidRequest{
+		BAdv: []string{"a.com"},
+		Imp:  []openrtb.Imp{{ID: "1", Banner: &openrtb.Banner{BAttr: []int{1, 2}}}, {ID: "2"}},
+	}
+
+	lists.Apply(newTestAppInventory(1, 10), req)
+
+	if len(req.BAdv) != 2 || req.BAdv[1] != "b.com" {
+		t.Errorf("BAdv = %v; want [a.com b.com]", req.BAdv)
+	}
+	if len(req.BCategory) != 1 || req.BCategory[0] != "IAB25" {
+		t.Errorf("BCategory = %v; want [IAB25]", req.BCategory)
+	}
+	if len(req.Imp[0].Banner.BAttr) != 2 {
+		t.Errorf("BAttr = %v; want [1 2]", req.Imp[0].Banner.BAttr)
+	}
+
+	other := &openrtb.BidRequest{}
+	lists.Apply(newTestAppInventory(2, 20), other)
+	if other.BAdv != nil || other.BCategory != nil {
+		t.Errorf("unknown publisher should leave the request untouched")
+	}
+}
+
+func TestAdomainBlocked_Allocs(t *testing.T) {
+	blocked := []string{"Blocked.com", "other.com"}
+	allocs := testing.AllocsPerRun(100, func() {
+		if !adomainBlocked(blocked, "ads.BLOCKED.com") || adomainBlocked(blocked, "Allowed.com") {
+			t.Fatal("unexpected match")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("allocs = %v; want 0", allocs)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Circuit breaker
+// Each DSP has a circuit breaker in the DSP IO. The breaker opens when the share of failed requests,
+// errors and timeouts alike, reaches a ratio within a window. While open, requests to the DSP are shed
+// right away instead of holding a worker and a connection. After a cool down, the breaker lets a few
+// probe requests through (half-open): if they all succeed it closes, otherwise it opens again.
+// Every state change starts a new generation. Allow hands out the generation a request was let through in,
+// and outcomes of requests from an earlier generation are ignored, so they are not taken for probes.
+// --
+
+// Circuit breaker states, as exposed by the dspio_breaker_state gauge.
+const (
+	BreakerClosed   = 0
+	BreakerOpen     = 1
+	BreakerHalfOpen = 2
+)
+
+// errBreakerOpen is returned for requests shed by an open circuit breaker.
+var errBreakerOpen = errors.New("dspio: circuit breaker is open")
+
+// BreakerConfig configures the DSP circuit breakers.
+type BreakerConfig struct {
+	Enabled bool
+	// Window is the period over which the failure ratio is measured while closed.
+	Window time.Duration
+	// MinRequests is the number of requests a window needs before the breaker may open.
+	MinRequests int
+	// FailureRatio is the share of failed requests that opens the breaker.
+	FailureRatio float64
+	// OpenTimeout is how long the breaker stays open before probing.
+	OpenTimeout time.Duration
+	// Probes is the number of successful probes needed to close the breaker.
+	Probes int
+}
+
+// CircuitBreaker is the circuit breaker of a single DSP. It is safe for concurrent use.
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	config   BreakerConfig
+	dspID    string
+	state    int
+	start    time.Time
+	requests int
+	failures int
+	openedAt time.Time
+	// inFlight and succeeded count the probes of the half-open state.
+	inFlight  int
+	succeeded int
+	// generation is incremented on every state change.
+	generation uint64
+}
+
+// NewCircuitBreaker creates a closed circuit breaker for the DSP.
+func NewCircuitBreaker(dspID int, config BreakerConfig) *CircuitBreaker {
+	b := &CircuitBreaker{config: config, dspID: strconv.Itoa(dspID), start: time.Now()}
+	gDSPBreakerState.WithLabelValues(b.dspID).Set(BreakerClosed)
+
+	return b
+}
+
+// Allow reports whether a request may be sent to the DSP at now, and the generation it is let through in.
+// Every allowed request must be followed by a call to Done or Release with that generation.
+func (b *CircuitBreaker) Allow(now time.Time) (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.config.OpenTimeout {
+			return 0, false
+		}
+		b.transition(BreakerHalfOpen, now)
+		fallthrough
+	case BreakerHalfOpen:
+		if b.inFlight+b.succeeded >= b.config.Probes {
+			return 0, false
+		}
+		b.inFlight++
+	}
+
+	return b.generation, true
+}
+
+// Done records the outcome of a request allowed in generation gen.
+// Outcomes of requests allowed before the last state change are ignored.
+func (b *CircuitBreaker) Done(gen uint64, success bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if gen != b.generation {
+		return
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		if now.Sub(b.start) >= b.config.Window {
+			b.start = now
+			b.requests = 0
+			b.failures = 0
+		}
+		b.requests++
+		if !success {
+			b.failures++
+		}
+		if b.requests >= b.config.MinRequests && float64(b.failures)/float64(b.requests) >= b.config.FailureRatio {
+			b.transition(BreakerOpen, now)
+		}
+	case BreakerHalfOpen:
+		b.inFlight = max(b.inFlight-1, 0)
+		if !success {
+			b.transition(BreakerOpen, now)
+			return
+		}
+		b.succeeded++
+		if b.succeeded >= b.config.Probes {
+			b.transition(BreakerClosed, now)
+		}
+	}
+}
+
+// Release gives back a request allowed in generation gen without recording an outcome,
+// e.g. because the queue was full or its auction closed.
+func (b *CircuitBreaker) Release(gen uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen && gen == b.generation {
+		b.inFlight = max(b.inFlight-1, 0)
+	}
+}
+
+// State returns the current state of the breaker.
+func (b *CircuitBreaker) State() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// transition moves the breaker to the given state. Callers must hold b.mu.
+func (b *CircuitBreaker) transition(state int, now time.Time) {
+	b.state = state
+	b.generation++
+	b.start = now
+	b.requests = 0
+	b.failures = 0
+	b.inFlight = 0
+	b.succeeded = 0
+	if state == BreakerOpen {
+		b.openedAt = now
+	}
+
+	gDSPBreakerState.WithLabelValues(b.dspID).Set(float64(state))
+	mDSPBreakerTransition.WithLabelValues(b.dspID, breakerStateLabel(state)).Inc()
+}
+
+func breakerStateLabel(state int) string {
+	switch state {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
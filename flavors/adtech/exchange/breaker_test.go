@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker(1, BreakerConfig{Enabled: true, Window: time.Minute, MinRequests: 4, FailureRatio: 0.5, OpenTimeout: time.Second, Probes: 2})
+	now := time.Now()
+
+	for _, success := range []bool{true, false, true} {
+		gen, ok := b.Allow(now)
+		if !ok {
+			t.Fatal("closed breaker should allow requests")
+		}
+		b.Done(gen, success, now)
+	}
+	if b.State() != BreakerClosed {
+		t.Fatal("breaker should stay closed below MinRequests")
+	}
+
+	gen, _ := b.Allow(now)
+	b.Done(gen, false, now)
+	if b.State() != BreakerOpen {
+		t.Fatalf("state = %d; want open", b.State())
+	}
+	if _, ok := b.Allow(now.Add(500 * time.Millisecond)); ok {
+		t.Fatal("open breaker should shed requests")
+	}
+
+	probe := now.Add(time.Second)
+	gen1, ok1 := b.Allow(probe)
+	_, ok2 := b.Allow(probe)
+	if !ok1 || !ok2 {
+		t.Fatal("half-open breaker should allow the probes")
+	}
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("state = %d; want half-open", b.State())
+	}
+	if _, ok := b.Allow(probe); ok {
+		t.Fatal("half-open breaker should not allow more than the probes")
+	}
+
+	b.Done(gen1, false, probe)
+	if b.State() != BreakerOpen {
+		t.Fatalf("failed probe: state = %d; want open", b.State())
+	}
+
+	probe = probe.Add(time.Second)
+	gen, _ = b.Allow(probe)
+	b.Allow(probe)
+	b.Release(gen)
+	if _, ok := b.Allow(probe); !ok {
+		t.Fatal("released probe should be given back")
+	}
+	b.Done(gen, true, probe)
+	b.Done(gen, true, probe)
+	if b.State() != BreakerClosed {
+		t.Fatalf("successful probes: state = %d; want closed", b.State())
+	}
+}
+
+func TestCircuitBreaker_IgnoresPreviousGeneration(t *testing.T) {
+	b := NewCircuitBreaker(1, BreakerConfig{Enabled: true, Window: time.Minute, MinRequests: 1, FailureRatio: 0.5, OpenTimeout: time.Second, Probes: 1})
+	now := time.Now()
+
+	// A slow request let through while closed, finishing after the breaker went half-open.
+	stale, _ := b.Allow(now)
+	gen, _ := b.Allow(now)
+	b.Done(gen, false, now)
+	if b.State() != BreakerOpen {
+		t.Fatalf("state = %d; want open", b.State())
+	}
+
+	probe := now.Add(time.Second)
+	gen, ok := b.Allow(probe)
+	if !ok {
+		t.Fatal("half-open breaker should allow the probe")
+	}
+
+	b.Done(stale, true, probe)
+	b.Release(stale)
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("stale outcome: state = %d; want half-open", b.State())
+	}
+	if _, ok := b.Allow(probe); ok {
+		t.Fatal("stale outcome should not free the probe")
+	}
+
+	b.Done(gen, true, probe)
+	if b.State() != BreakerClosed {
+		t.Fatalf("probe: state = %d; want closed", b.State())
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"time"
+)
+
+// Budget
+// The time budget of an ad request is the smaller of the inbound tmax and the configured maximum.
+// DSPs must answer before the budget minus a safety margin, left for the auction and the response encoding.
+// The margin takes at most a quarter of the budget, so a small tmax still leaves time for the DSPs.
+// Each phase of the request records how much of the budget it used.
+// --
+
+// Ad request phases.
+const (
+	PhaseDecode  = "decode"
+	PhasePrepare = "prepare"
+	PhaseFanOut  = "fanout"
+	PhaseWait    = "wait"
+	PhaseAuction = "auction"
+	PhaseEncode  = "encode"
+)
+
+// maxMarginShare is the largest share of the budget taken by the safety margin, as a divisor.
+const maxMarginShare = 4
+
+// Budget tracks the time budget of an ad request.
+type Budget struct {
+	last     time.Time
+	total    time.Duration
+	deadline time.Time
+}
+
+// NewBudget creates the budget of a request that started at start.
+// tmax is the inbound BidRequest.TMax in milliseconds; 0 means no limit other than maxBudget.
+func NewBudget(start time.Time, tmax int, maxBudget, margin time.Duration) *Budget {
+	total := maxBudget
+	if tmax > 0 {
+		total = min(time.Duration(tmax)*time.Millisecond, maxBudget)
+	}
+	margin = min(margin, total/maxMarginShare)
+
+	return &Budget{
+		last:     start,
+		total:    total,
+		deadline: start.Add(total - margin),
+	}
+}
+
+// Total returns the whole budget of the request.
+func (b *Budget) Total() time.Duration {
+	return b.total
+}
+
+// Deadline returns the instant the DSPs must have answered by.
+func (b *Budget) Deadline() time.Time {
+	return b.deadline
+}
+
+// Remaining returns the time left until the deadline. It is never negative.
+func (b *Budget) Remaining() time.Duration {
+	return max(time.Until(b.deadline), 0)
+}
+
+// Mark records the time spent since the previous mark, or since the request started, as the given phase.
+func (b *Budget) Mark(phase string) {
+	now := time.Now()
+	elapsed := now.Sub(b.last)
+	b.last = now
+
+	hAdRequestPhaseDuration.WithLabelValues(phase).Observe(elapsed.Seconds())
+	if b.total > 0 {
+		hAdRequestPhaseBudget.WithLabelValues(phase).Observe(float64(elapsed) / float64(b.total))
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewBudget(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name         string
+		tmax         int
+		wantTotal    time.Duration
+		wantDeadline time.Duration
+	}{
+		{"no tmax", 0, 500 * time.Millisecond, 450 * time.Millisecond},
+		{"tmax above max", 1000, 500 * time.Millisecond, 450 * time.Millisecond},
+		{"tmax below max", 200, 200 * time.Millisecond, 150 * time.Millisecond},
+		{"tmax at margin", 50, 50 * time.Millisecond, 37500 * time.Microsecond},
+		{"tmax below margin", 20, 20 * time.Millisecond, 15 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBudget(start, tt.tmax, 500*time.Millisecond, 50*time.Millisecond)
+			if b.Total() != tt.wantTotal {
+				t.Errorf("Total() = %v; want %v", b.Total(), tt.wantTotal)
+			}
+			if got := b.Deadline().Sub(start); got != tt.wantDeadline {
+				t.Errorf("deadline = start + %v; want start + %v", got, tt.wantDeadline)
+			}
+		})
+	}
+}
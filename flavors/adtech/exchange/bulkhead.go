@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Bulkheads
+// Every DSP has its own bounded queue and its own workers, so a slow DSP only fills its own queue
+// and drops its own requests, while the other DSPs keep being served.
+// Queues are created on the first request to a DSP, sized by the DSP config or the DSP IO defaults.
+// Changes to the sizes of an existing queue apply after a restart.
+// --
+
+// dspQueue is the queue of a single DSP.
+// limiter is nil unless an adaptive concurrency limit is configured.
+type dspQueue struct {
+	input   chan In
+	depth   prometheus.Gauge
+	wait    prometheus.Observer
+	limiter *ConcurrencyLimiter
+}
+
+// queue returns the queue of the DSP of the request, creating it and starting its workers if needed.
+func (d *DSPIO) queue(in *In) *dspQueue {
+	if v, ok := d.queues.Load(in.DSPID); ok {
+		return v.(*dspQueue)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if v, ok := d.queues.Load(in.DSPID); ok {
+		return v.(*dspQueue)
+	}
+
+	workers, size := d.workers, d.queueSize
+	if in.Workers > 0 {
+		workers = in.Workers
+	}
+	if in.Queue > 0 {
+		size = in.Queue
+	}
+
+	dspIDStr := strconv.Itoa(in.DSPID)
+	q := &dspQueue{
+		input: make(chan In, size),
+		depth: gDSPQueueDepth.WithLabelValues(dspIDStr),
+		wait:  hDSPQueueWait.WithLabelValues(dspIDStr),
+	}
+	gDSPWorkers.WithLabelValues(dspIDStr).Set(float64(workers))
+
+	if !isStaticLimit(d.limit.Algorithm) {
+		// NewDSPIO already rejected unknown algorithms.
+		algorithm, _ := NewLimitAlgorithm(d.limit.Algorithm)
+		q.limiter = NewConcurrencyLimiter(algorithm, d.limit, gDSPConcurrencyLimit.WithLabelValues(dspIDStr))
+	} else {
+		gDSPConcurrencyLimit.WithLabelValues(dspIDStr).Set(float64(workers))
+	}
+
+	for range workers {
+		go d.worker(d.ctx, q)
+	}
+
+	d.queues.Store(in.DSPID, q)
+
+	return q
+}
+
+// worker executes the requests of a DSP queue until the DSP IO stops.
+func (d *DSPIO) worker(ctx context.Context, q *dspQueue) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.done:
+			return
+		case in := <-q.input:
+			q.depth.Set(float64(len(q.input)))
+			q.wait.Observe(time.Since(in.Timestamp).Seconds())
+			d.Execute(in)
+		}
+	}
+}
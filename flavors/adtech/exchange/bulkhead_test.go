@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDSPIO_Bulkheads(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		io.WriteString(w, `{"id":"slow"}`)
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"id":"fast"}`)
+	}))
+	defer fast.Close()
+
+	d := newTestDSPIO()
+	d.Start(t.Context())
+	defer d.Stop()
+
+	responses := make(chan Out, 10)
+	enqueue := func(dspID int, url string) bool {
+		in := newTestIn(t, url, false, 0)
+		in.DSPID = dspID
+		in.Responder = responses
+		in.Timestamp = time.Now()
+		return d.Enqueue(in)
+	}
+
+	// The slow DSP has one worker and a queue of one: the third request is dropped.
+	enqueue(1, slow.URL)
+	<-started
+	enqueue(1, slow.URL)
+	if enqueue(1, slow.URL) {
+		t.Error("the dropped request was reported as queued")
+	}
+	select {
+	case out := <-responses:
+		if out.DSPID != 1 || out.Err == nil {
+			t.Fatalf("out = %+v; want a drop of DSP 1", out)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the slow DSP queue should drop its third request")
+	}
+
+	enqueue(2, fast.URL)
+	select {
+	case out := <-responses:
+		if out.DSPID != 2 || out.Err != nil || out.BidResponse.ID != "fast" {
+			t.Fatalf("out = %+v; want a response of DSP 2", out)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the fast DSP should not be starved by the slow one")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Clients
+// The DSP IO sends requests through a Doer, so the outbound HTTP client can be swapped for experiments:
+//   - nethttp: the net/http transport, with HTTP/2 over TLS when negotiated.
+//   - http1: HTTP1Client, a minimal HTTP/1.1 client with its own connection pool and optional pipelining.
+//   - h2c: the net/http transport speaking cleartext HTTP/2 to http:// endpoints.
+// Every client dials through the same dialer and is timed by the DSP IO, so they report the same metrics.
+// --
+
+// Outbound HTTP clients of the DSP IO.
+const (
+	ClientNetHTTP = "nethttp"
+	ClientHTTP1   = "http1"
+	ClientH2C     = "h2c"
+)
+
+// Doer sends an HTTP request and returns its response. Redirects are not followed.
+// Implementations must be safe for concurrent use. The DSP IO does not use a response after closing its body,
+// so implementations may recycle it.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// RoundTripperDoer adapts an http.RoundTripper to the Doer interface.
+type RoundTripperDoer struct {
+	http.RoundTripper
+}
+
+// Do sends the request with the round tripper.
+func (d RoundTripperDoer) Do(req *http.Request) (*http.Response, error) {
+	return d.RoundTrip(req)
+}
+
+// NewDoer creates the named client. The http1 and h2c clients reuse the dialer, TLS and pool settings of transport.
+// pipeline is the number of requests the http1 client pipelines on each connection.
+func NewDoer(name string, transport *http.Transport, pipeline int) (Doer, error) {
+	switch name {
+	case ClientNetHTTP, "":
+		return RoundTripperDoer{transport}, nil
+	case ClientHTTP1:
+		return NewHTTP1Client(HTTP1Config{
+			DialContext:     transport.DialContext,
+			TLSConfig:       transport.TLSClientConfig,
+			MaxConnsPerHost: transport.MaxIdleConnsPerHost,
+			Pipeline:        pipeline,
+			IdleConnTimeout: transport.IdleConnTimeout,
+		}), nil
+	case ClientH2C:
+		h2c := transport.Clone()
+		// Without HTTP1, http:// requests use HTTP/2 with prior knowledge; https:// requests still negotiate HTTP/2.
+		h2c.Protocols = new(http.Protocols)
+		h2c.Protocols.SetUnencryptedHTTP2(true)
+		h2c.Protocols.SetHTTP2(true)
+		return RoundTripperDoer{h2c}, nil
+	default:
+		return nil, fmt.Errorf("dspio: unknown client %q", name)
+	}
+}
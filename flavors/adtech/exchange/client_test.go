@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newEchoServer starts a server answering with the request body, and counts the connections it accepts.
+func newEchoServer(t *testing.T, conns *atomic.Int32, tls bool) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Query().Get("mode") {
+		case "nobid":
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case "chunked":
+			w.Write(body[:1])
+			w.(http.Flusher).Flush()
+			w.Write(body[1:])
+			return
+		case "block":
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	if tls {
+		server.StartTLS()
+	} else {
+		server.Start()
+	}
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func doEcho(ctx context.Context, doer Doer, url, body string) (string, *http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader([]byte(body)))
+	if err != nil {
+		return "", nil, err
+	}
+
+	res, err := doer.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The http1 client recycles the response once its body is closed, so a copy is returned.
+	b, err := io.ReadAll(res.Body)
+	head := &http.Response{StatusCode: res.StatusCode, Header: res.Header.Clone()}
+	res.Body.Close()
+
+	return string(b), head, err
+}
+
+func TestHTTP1Client_Do(t *testing.T) {
+	for _, useTLS := range []bool{false, true} {
+		t.Run(fmt.Sprintf("tls=%v", useTLS), func(t *testing.T) {
+			var conns atomic.Int32
+			server := newEchoServer(t, &conns, useTLS)
+			client := NewHTTP1Client(HTTP1Config{MaxConnsPerHost: 4, TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+
+			for i := range 10 {
+				want := `{"id":"` + strconv.Itoa(i) + `"}`
+				got, res, err := doEcho(t.Context(), client, server.URL+"/bid?latency=1ms", want)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want || res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("response = %d %q %q; want 200 %q application/json", res.StatusCode, got, res.Header.Get("Content-Type"), want)
+				}
+			}
+
+			if _, res, err := doEcho(t.Context(), client, server.URL+"?mode=nobid", "{}"); err != nil || res.StatusCode != http.StatusNoContent {
+				t.Errorf("no-bid = %v, %v; want 204", res, err)
+			}
+			if got, _, err := doEcho(t.Context(), client, server.URL+"?mode=chunked", "chunked body"); err != nil || got != "chunked body" {
+				t.Errorf("chunked = %q, %v", got, err)
+			}
+
+			if n := conns.Load(); n != 1 {
+				t.Errorf("connections = %d; want 1, reused by sequential requests", n)
+			}
+		})
+	}
+}
+
+func TestHTTP1Client_Pipeline(t *testing.T) {
+	var conns atomic.Int32
+	server := newEchoServer(t, &conns, false)
+	client := NewHTTP1Client(HTTP1Config{MaxConnsPerHost: 2, Pipeline: 4})
+
+	var wg sync.WaitGroup
+	for i := range 32 {
+		wg.Go(func() {
+			want := strconv.Itoa(i)
+			got, _, err := doEcho(t.Context(), client, server.URL, want)
+			if err != nil || got != want {
+				t.Errorf("request %d = %q, %v", i, got, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if n := conns.Load(); n > 2 {
+		t.Errorf("connections = %d; want at most 2", n)
+	}
+}
+
+func TestHTTP1Client_Informational(t *testing.T) {
+	// The server reads both pipelined requests, then answers each one with 100 and 103 before its final response.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		var bodies []string
+		for range 2 {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			body, _ := io.ReadAll(req.Body)
+			bodies = append(bodies, string(body))
+		}
+		for _, body := range bodies {
+			fmt.Fprintf(conn, "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </bid.js>\r\n\r\n"+
+				"HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+		}
+	}()
+
+	client := NewHTTP1Client(HTTP1Config{MaxConnsPerHost: 1, Pipeline: 2})
+
+	var wg sync.WaitGroup
+	for i := range 2 {
+		wg.Go(func() {
+			want := strconv.Itoa(i)
+			got, res, err := doEcho(t.Context(), client, "http://"+ln.Addr().String(), want)
+			if err != nil {
+				t.Errorf("request %d: %v", i, err)
+			} else if res.StatusCode != http.StatusOK || got != want {
+				t.Errorf("request %d = %d %q; want 200 %q", i, res.StatusCode, got, want)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestHTTP1Client_Cancel(t *testing.T) {
+	var conns atomic.Int32
+	server := newEchoServer(t, &conns, false)
+	client := NewHTTP1Client(HTTP1Config{})
+
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, _, err := doEcho(ctx, client, server.URL+"?mode=block", "{}"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v; want %v", err, context.DeadlineExceeded)
+	}
+
+	// The canceled request closed its connection; the next one dials a new connection.
+	if got, _, err := doEcho(t.Context(), client, server.URL, "ok"); err != nil || got != "ok" {
+		t.Errorf("after cancel = %q, %v", got, err)
+	}
+	if n := conns.Load(); n != 2 {
+		t.Errorf("connections = %d; want 2", n)
+	}
+}
+
+func TestHTTP1Client_CancelStalledWrite(t *testing.T) {
+	// The server accepts connections but never reads, so a large request stalls the write.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+
+	body := bytes.Repeat([]byte("x"), 32<<20)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+ln.Addr().String(), bytes.NewReader(body))
+
+	start := time.Now()
+	if _, err := NewHTTP1Client(HTTP1Config{}).Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v; want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("stalled write canceled after %v; want right after the deadline", elapsed)
+	}
+}
+
+func TestHTTP1Client_IdleTimeout(t *testing.T) {
+	var closed atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client := NewHTTP1Client(HTTP1Config{IdleConnTimeout: 20 * time.Millisecond})
+	if _, _, err := doEcho(t.Context(), client, server.URL, "{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The idle connection is closed without another request to the host.
+	for deadline := time.Now().Add(time.Second); closed.Load() == 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("idle connection was not closed")
+		}
+	}
+}
+
+func TestNewDoer(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, r.Proto)
+	}))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	for name, want := range map[string]string{ClientNetHTTP: "HTTP/1.1", ClientHTTP1: "HTTP/1.1", ClientH2C: "HTTP/2.0"} {
+		doer, err := NewDoer(name, transport, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _, err := doEcho(t.Context(), doer, server.URL, "{}"); err != nil || got != want {
+			t.Errorf("%s: proto = %q, %v; want %s", name, got, err, want)
+		}
+	}
+
+	if _, err := NewDoer("unknown", transport, 1); err == nil {
+		t.Error("expected an error for an unknown client")
+	}
+}
+
+func BenchmarkDoer(b *testing.B) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, `{"id":"1"}`)
+	}))
+	defer server.Close()
+
+	body := []byte(`{"id":"1","imp":[{"id":"1"}]}`)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	for _, name := range []string{ClientNetHTTP, ClientHTTP1} {
+		b.Run(name, func(b *testing.B) {
+			doer, err := NewDoer(name, transport, 1)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			for b.Loop() {
+				req, _ := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader(body))
+				res, err := doer.Do(req)
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, res.Body)
+				res.Body.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"perftest/libs/openrtb"
+)
+
+// Currency
+// Bids and floors are normalised to the exchange currency before the auction.
+// Rates are expressed against a base currency, so any pair of known currencies can be converted.
+// --
+
+// DefaultCurrency is the currency assumed by OpenRTB when none is given.
+const DefaultCurrency = "USD"
+
+// CurrencyRates holds the conversion rates for every known currency.
+// Rates[c] is the amount of c equivalent to one unit of Base.
+type CurrencyRates struct {
+	Base  string             `json:"base"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+// Convert converts amount from one currency to another.
+// It returns false when either currency is unknown.
+// Converting a currency to itself always succeeds, even with a nil CurrencyRates.
+func (c *CurrencyRates) Convert(amount float64, from, to string) (float64, bool) {
+	if from == "" {
+		from = DefaultCurrency
+	}
+	if to == "" {
+		to = DefaultCurrency
+	}
+	if from == to {
+		return amount, true
+	}
+	if c == nil {
+		return 0, false
+	}
+
+	fromRate, ok := c.Rates[from]
+	if !ok {
+		return 0, false
+	}
+	toRate, ok := c.Rates[to]
+	if !ok {
+		return 0, false
+	}
+
+	return amount / fromRate * toRate, true
+}
+
+// NormalizeFloors converts the floor of every impression and deal to the given currency.
+// The converted floors are sent to the DSPs, so every bidder sees floors in the exchange currency.
+func (c *CurrencyRates) NormalizeFloors(request *openrtb.BidRequest, currency string) error {
+	for i := range request.Imp {
+		imp := &request.Imp[i]
+		floor, ok := c.Convert(imp.BidFloor, imp.BidFloorCur, currency)
+		if !ok {
+			return fmt.Errorf("currency: unknown floor currency %q for imp %q", imp.BidFloorCur, imp.ID)
+		}
+		imp.BidFloor = floor
+		imp.BidFloorCur = currency
+
+		if imp.PMP == nil {
+			continue
+		}
+		for j := range imp.PMP.Deals {
+			deal := &imp.PMP.Deals[j]
+			floor, ok := c.Convert(deal.BidFloor, deal.BidFloorCur, currency)
+			if !ok {
+				return fmt.Errorf("currency: unknown floor currency %q for deal %q", deal.BidFloorCur, deal.ID)
+			}
+			deal.BidFloor = floor
+			deal.BidFloorCur = currency
+		}
+	}
+
+	return nil
+}
+
+// CacheLoadCurrencyRates loads the currency rates from the given path.
+func CacheLoadCurrencyRates(path string) CacheLoadFunc {
+	return func(state *State, logger *slog.Logger) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		var decoded CurrencyRates
+		if err = json.NewDecoder(f).Decode(&decoded); err != nil {
+			return err
+		}
+
+		if decoded.Base == "" {
+			return errors.New("currency: missing base currency")
+		}
+
+		rates := &CurrencyRates{Base: decoded.Base, Rates: make(map[string]float64, len(decoded.Rates)+1)}
+		for cur, rate := range decoded.Rates {
+			if rate <= 0 {
+				logger.Warn("cache: ignoring non-positive currency rate", slog.String("currency", cur), slog.Float64("rate", rate))
+				continue
+			}
+			rates.Rates[cur] = rate
+		}
+		rates.Rates[decoded.Base] = 1
+
+		state.CurrencyRates.Store(rates)
+
+		logger.Info("cache: loaded currency rates", slog.String("base", rates.Base), slog.Int("count", len(rates.Rates)))
+
+		return nil
+	}
+}
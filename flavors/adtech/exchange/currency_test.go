@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"perftest/libs/openrtb"
+)
+
+func TestCurrencyRates_Convert(t *testing.T) {
+	rates := &CurrencyRates{Base: "USD", Rates: map[string]float64{"USD": 1, "EUR": 0.5, "BRL": 5}}
+
+	tests := []struct {
+		name   string
+		from   string
+		to     string
+		amount float64
+		want   float64
+		ok     bool
+	}{
+		{"same currency", "EUR", "EUR", 2, 2, true},
+		{"default currency", "", "USD", 2, 2, true},
+		{"to base", "EUR", "USD", 2, 4, true},
+		{"cross rate", "EUR", "BRL", 1, 10, true},
+		{"unknown", "GBP", "USD", 1, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := rates.Convert(tt.amount, tt.from, tt.to)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("Convert(%v, %q, %q) = %v, %v; want %v, %v", tt.amount, tt.from, tt.to, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCurrencyRates_NilOnlyConvertsSameCurrency(t *testing.T) {
+	var rates *CurrencyRates
+	if got, ok := rates.Convert(1, "USD", ""); !ok || got != 1 {
+		t.Errorf("Convert(USD, default) = %v, %v; want 1, true", got, ok)
+	}
+	if _, ok := rates.Convert(1, "EUR", "USD"); ok {
+		t.Errorf("Convert(EUR, USD) should fail without rates")
+	}
+}
+
+func TestCurrencyRates_NormalizeFloors(t *testing.T) {
+	rates := &CurrencyRates{Base: "USD", Rates: map[string]float64{"USD": 1, "EUR": 0.5}}
+	req := &openrtb.BidRequest{Imp: []openrtb.Imp{
+		{ID: "1", BidFloor: 1, BidFloorCur: "EUR"},
+		{ID: "2", BidFloor: 1},
+	}}
+	if err := rates.NormalizeFloors(req, "USD"); err != nil {
+		t.Fatalf("NormalizeFloors() error = %v", err)
+	}
+	if req.Imp[0].BidFloor != 2 || req.Imp[0].BidFloorCur != "USD" {
+		t.Errorf("imp 1 floor = %v %s; want 2 USD", req.Imp[0].BidFloor, req.Imp[0].BidFloorCur)
+	}
+	if req.Imp[1].BidFloor != 1 || req.Imp[1].BidFloorCur != "USD" {
+		t.Errorf("imp 2 floor = %v %s; want 1 USD", req.Imp[1].BidFloor, req.Imp[1].BidFloorCur)
+	}
+
+	req = &openrtb.BidRequest{Imp: []openrtb.Imp{{ID: "1", BidFloor: 1, BidFloorCur: "GBP"}}}
+	if err := rates.NormalizeFloors(req, "USD"); err == nil {
+		t.Errorf("NormalizeFloors() with unknown currency should fail")
+	}
+}
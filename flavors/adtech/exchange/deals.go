@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+
+	"perftest/libs/intern"
+	"perftest/libs/openrtb"
+)
+
+// Deals
+// The deals catalogue holds the private marketplace deals offered per publisher or app.
+// Matching deals are injected into Imp.PMP before the fan-out, and the auction only accepts
+// deal bids for deals that were offered.
+// --
+
+// Auction type of a deal whose floor is the fixed deal price.
+const AuctionTypeFixedPrice = 3
+
+// DealEntry represents a deal of the catalogue.
+// Exactly one of PublisherID or AppID is expected to be set.
+type DealEntry struct {
+	ID             string   `json:"id"`
+	PublisherID    int      `json:"publisher_id,omitempty"`
+	AppID          int      `json:"app_id,omitempty"`
+	BidFloor       float64  `json:"bidfloor,omitempty"`
+	BidFloorCur    string   `json:"bidfloorcur,omitempty"`
+	WSeat          []string `json:"wseat,omitempty"`
+	AT             int      `json:"at,omitempty"`
+	PrivateAuction bool     `json:"private_auction,omitempty"`
+}
+
+// Deals holds the deals catalogue indexed for quick lookup.
+type Deals struct {
+	ByPublisher map[int][]*DealEntry
+	ByApp       map[int][]*DealEntry
+}
+
+// Apply injects the deals of the app, if any, and of the inventory publisher into every impression of the request.
+// Deals already present in the request are kept. If any injected deal is private,
+// the impressions are flagged as private auctions.
+// A nil Deals leaves the request untouched.
+func (d *Deals) Apply(inv *Inventory, request *openrtb.BidRequest) {
+	if d == nil || inv == nil {
+		return
+	}
+
+	var entries []*DealEntry
+	if inv.App != nil {
+		entries = d.ByApp[inv.App.ID]
+	}
+	if inv.Publisher != nil {
+		if pub := d.ByPublisher[inv.Publisher.ID]; len(pub) > 0 {
+			entries = append(entries[:len(entries):len(entries)], pub...)
+		}
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	for i := range request.Imp {
+		imp := &request.Imp[i]
+		if imp.PMP == nil {
+			imp.PMP = &openrtb.PMP{}
+		}
+
+		for _, entry := range entries {
+			if findDeal(imp, entry.ID) != nil {
+				continue
+			}
+
+			imp.PMP.Deals = append(imp.PMP.Deals, openrtb.Deal{
+				ID:          entry.ID,
+				BidFloor:    entry.BidFloor,
+				BidFloorCur: entry.BidFloorCur,
+				WSeat:       entry.WSeat,
+				AT:          entry.AT,
+			})
+			if entry.PrivateAuction {
+				imp.PMP.PrivateAuction = 1
+			}
+		}
+	}
+}
+
+// CacheLoadDeals loads the deals catalogue from the given path.
+func CacheLoadDeals(path string, useIntern bool) CacheLoadFunc {
+	return func(state *State, logger *slog.Logger) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		var decoded []*DealEntry
+		if err = json.NewDecoder(f).Decode(&decoded); err != nil {
+			return err
+		}
+
+		deals := &Deals{
+			ByPublisher: make(map[int][]*DealEntry),
+			ByApp:       make(map[int][]*DealEntry),
+		}
+		for _, entry := range decoded {
+			if useIntern {
+				entry.ID = intern.InternString(entry.ID)
+				for i := range entry.WSeat {
+					entry.WSeat[i] = intern.InternString(entry.WSeat[i])
+				}
+			}
+
+			switch {
+			case entry.AppID != 0:
+				deals.ByApp[entry.AppID] = append(deals.ByApp[entry.AppID], entry)
+			case entry.PublisherID != 0:
+				deals.ByPublisher[entry.PublisherID] = append(deals.ByPublisher[entry.PublisherID], entry)
+			default:
+				logger.Warn("cache: deal without publisher or app", slog.String("deal_id", entry.ID))
+			}
+		}
+
+		state.Deals.Store(deals)
+
+		logger.Info("cache: loaded deals", slog.Int("count", len(decoded)))
+
+		return nil
+	}
+}
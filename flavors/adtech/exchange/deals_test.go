@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"perftest/libs/openrtb"
+)
+
+func TestDeals_Apply(t *testing.T) {
+	deals := &Deals{
+		ByPublisher: map[int][]*DealEntry{10: {{ID: "pub-deal", BidFloor: 1}}},
+		ByApp:       map[int][]*DealEntry{100: {{ID: "app-deal", BidFloor: 2, PrivateAuction: true}}},
+	}
+	req := &openrtb.BidRequest{Imp: []openrtb.Imp{
+		{ID: "1"},
+		{ID: "2", PMP: &openrtb.PMP{Deals: []openrtb.Deal{{ID: "pub-deal", BidFloor: 5}}}},
+	}}
+
+	deals.Apply(newTestAppInventory(100, 10), req)
+
+	if got := len(req.Imp[0].PMP.Deals); got != 2 {
+		t.Fatalf("imp 1 deals = %d; want 2", got)
+	}
+	if req.Imp[0].PMP.PrivateAuction != 1 {
+		t.Errorf("imp 1 should be a private auction")
+	}
+	if got := len(req.Imp[1].PMP.Deals); got != 2 {
+		t.Fatalf("imp 2 deals = %d; want 2", got)
+	}
+	if req.Imp[1].PMP.Deals[0].BidFloor != 5 {
+		t.Errorf("deal sent in the request should be kept as is")
+	}
+
+	other := &openrtb.BidRequest{Imp: []openrtb.Imp{{ID: "1"}}}
+	deals.Apply(newTestAppInventory(200, 20), other)
+	if other.Imp[0].PMP != nil {
+		t.Errorf("app without deals should leave the request untouched")
+	}
+}
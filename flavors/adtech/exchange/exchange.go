@@ -1,21 +1,20 @@
 package main
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
 	"net/http/pprof"
-	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -49,16 +48,38 @@ type Apps struct {
 }
 
 // DSP represents a DSP with its endpoint and optional latency.
+// Seats are the buyer seats the DSP bids with; a DSP without seats bids with its ID as the only seat.
+// Capabilities and Targeting are optional and restrict the requests the DSP is sent.
+// QPS caps the requests per second sent to the DSP, with bursts of up to Burst requests; 0 means no cap.
+// Hedge and Retries opt the DSP in to hedged requests and to retries on connection-level errors.
+// Workers and Queue override the DSP IO defaults of the DSP bulkhead.
+// URL is the endpoint with the latency query, built when the cache loads.
+// Adapter, Encoding and Transforms customise the requests of the DSP; see DSPEncoding and DSPTransforms.
 type DSP struct {
-	ID       int    `json:"id"`
-	Name     string `json:"name"`
-	Endpoint string `json:"endpoint"`
-	Latency  string `json:"latency"`
+	ID           int              `json:"id"`
+	Name         string           `json:"name"`
+	Endpoint     string           `json:"endpoint"`
+	Latency      string           `json:"latency"`
+	Seats        []string         `json:"seats,omitempty"`
+	Capabilities *DSPCapabilities `json:"capabilities,omitempty"`
+	Targeting    *DSPTargeting    `json:"targeting,omitempty"`
+	QPS          float64          `json:"qps,omitempty"`
+	Burst        int              `json:"burst,omitempty"`
+	Hedge        bool             `json:"hedge,omitempty"`
+	Retries      int              `json:"retries,omitempty"`
+	Workers      int              `json:"workers,omitempty"`
+	Queue        int              `json:"queue,omitempty"`
+	Adapter      string           `json:"adapter,omitempty"`
+	Encoding     *DSPEncoding     `json:"encoding,omitempty"`
+	Transforms   *DSPTransforms   `json:"transforms,omitempty"`
+	URL          string           `json:"-"`
+	adapter      Adapter
 }
 
-// DSPs holds a map of DSPs for quick lookup.
+// DSPs holds the list of DSPs and a map of DSPs by ID for quick lookup.
 type DSPs struct {
 	DSPs []*DSP
+	ByID map[int]*DSP
 }
 
 // Cache
@@ -72,8 +93,13 @@ type DSPs struct {
 type CacheLoadFunc func(state *State, logger *slog.Logger) error
 
 type State struct {
-	Apps atomic.Pointer[Apps]
-	DSPs atomic.Pointer[DSPs]
+	Apps          atomic.Pointer[Apps]
+	Sites         atomic.Pointer[Sites]
+	DSPs          atomic.Pointer[DSPs]
+	Floors        atomic.Pointer[Floors]
+	CurrencyRates atomic.Pointer[CurrencyRates]
+	Blocklists    atomic.Pointer[Blocklists]
+	Deals         atomic.Pointer[Deals]
 }
 
 // Cache manages the in-memory cache objects needed by the application.
@@ -207,22 +233,49 @@ func CacheLoadDSPs(path string, useIntern bool) CacheLoadFunc {
 		}
 
 		dsps := make([]*DSP, len(decoded))
+		byID := make(map[int]*DSP, len(decoded))
 		for i, src := range decoded {
 			dsp := &DSP{
-				ID:       src.ID,
-				Name:     src.Name,
-				Endpoint: src.Endpoint,
-				Latency:  src.Latency,
+				ID:           src.ID,
+				Name:         src.Name,
+				Endpoint:     src.Endpoint,
+				Latency:      src.Latency,
+				Seats:        src.Seats,
+				Capabilities: src.Capabilities,
+				Targeting:    src.Targeting,
+				QPS:          src.QPS,
+				Burst:        src.Burst,
+				Hedge:        src.Hedge,
+				Retries:      src.Retries,
+				Workers:      src.Workers,
+				Queue:        src.Queue,
+				Adapter:      src.Adapter,
+				Encoding:     src.Encoding,
+				Transforms:   src.Transforms,
+			}
+			if len(dsp.Seats) == 0 {
+				dsp.Seats = []string{strconv.Itoa(dsp.ID)}
+			}
+			if dsp.URL, err = dspURL(dsp.Endpoint, dsp.Latency); err != nil {
+				return fmt.Errorf("cache: dsp %d: %w", dsp.ID, err)
+			}
+			if dsp.adapter, err = buildAdapter(dsp); err != nil {
+				return fmt.Errorf("cache: dsp %d: %w", dsp.ID, err)
 			}
 			if useIntern {
 				dsp.Name = intern.InternString(dsp.Name)
 				dsp.Endpoint = intern.InternString(dsp.Endpoint)
 				dsp.Latency = intern.InternString(dsp.Latency)
+				dsp.URL = intern.InternString(dsp.URL)
+				for j := range dsp.Seats {
+					dsp.Seats[j] = intern.InternString(dsp.Seats[j])
+				}
 			}
 			dsps[i] = dsp
+			byID[dsp.ID] = dsp
 		}
 
-		state.DSPs.Store(&DSPs{DSPs: dsps})
+		state.DSPs.Store(&DSPs{DSPs: dsps, ByID: byID})
 
 		for _, dsp := range dsps {
 			gDSPConfigInfo.WithLabelValues(strconv.Itoa(dsp.ID)).Set(1)
@@ -236,15 +289,28 @@ func CacheLoadDSPs(path string, useIntern bool) CacheLoadFunc {
 
 // DSP IO
 // The DSP IO is responsible for handling the DSP requests and responses.
+// Requests to DSPs whose circuit breaker is open are answered with an error without being sent.
+// Each DSP has its own queue and workers, see Bulkheads.
 // --
 
 // In represents the input to execute a DSP request.
+// Hedge and Retries carry the DSP policy for hedged and retried requests.
+// Workers and Queue size the DSP bulkhead; 0 means the DSP IO default.
+// Deadline is when the auction stops waiting and Closed is closed once it does; a nil Closed never closes.
 type In struct {
 	ID         int
 	DSPID      int
 	BidRequest *http.Request
 	Responder  chan<- Out
 	Timestamp  time.Time
+	Hedge      bool
+	Retries    int
+	Workers    int
+	Queue      int
+	Deadline   time.Time
+	Closed     <-chan struct{}
+	// breakerGen is the circuit breaker generation the request was allowed in.
+	breakerGen uint64
 }
 
 // Out represents the response of a DSP request.
@@ -257,41 +323,53 @@ type Out struct {
 
 // DSPIO represents the actual DSP IO handler.
 type DSPIO struct {
-	logger    *slog.Logger
-	transport *http.Transport
-	pool      int
-	input     chan In
-	done      chan struct{}
+	logger *slog.Logger
+	client Doer
+	// workers and queueSize are the default bulkhead of a DSP.
+	workers   int
+	queueSize int
+	breaker   BreakerConfig
+	limit     LimitConfig
+	breakers  sync.Map // int -> *CircuitBreaker
+	latencies sync.Map // int -> *LatencyTracker
+	// retryMinBudget is the minimum remaining budget for a retry when the DSP latency is still unknown.
+	retryMinBudget time.Duration
+	mu             sync.Mutex
+	queues         sync.Map // int -> *dspQueue
+	ctx            context.Context
+	done           chan struct{}
 }
 
 // NewDSPIO creates a new DSP IO handler.
-func NewDSPIO(logger *slog.Logger, transport *http.Transport, pool int) *DSPIO {
+// client sends the DSP requests; see Clients.
+// workers and queue are the default number of workers and queue size of each DSP.
+// It panics when limit has an unknown algorithm.
+func NewDSPIO(logger *slog.Logger, client Doer, workers, queue int, breaker BreakerConfig, limit LimitConfig, retryMinBudget time.Duration) *DSPIO {
+	if !isStaticLimit(limit.Algorithm) {
+		if _, err := NewLimitAlgorithm(limit.Algorithm); err != nil {
+			panic(err)
+		}
+	}
+
 	return &DSPIO{
-		logger:    logger,
-		transport: transport,
-		pool:      pool,
-		input:     make(chan In),
-		done:      make(chan struct{}),
+		logger:         logger,
+		client:         client,
+		workers:        workers,
+		queueSize:      queue,
+		breaker:        breaker,
+		limit:          limit,
+		retryMinBudget: retryMinBudget,
+		ctx:            context.Background(),
+		done:           make(chan struct{}),
 	}
 }
 
-// Start starts the DSP IO background workers.
-// The workers will execute the DSP requests in the background.
+// Start starts the DSP IO.
+// The workers of each DSP are started with its queue, on the first request to the DSP, and stop with ctx.
 func (d *DSPIO) Start(ctx context.Context) {
-	for range d.pool {
-		go func() {
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-d.done:
-					return
-				case in := <-d.input:
-					d.Execute(in)
-				}
-			}
-		}()
-	}
+	d.mu.Lock()
+	d.ctx = ctx
+	d.mu.Unlock()
 }
 
 // Stop stops the DSP IO background workers.
@@ -300,19 +378,50 @@ func (d *DSPIO) Stop() {
 }
 
 // Enqueue enqueues a DSP request to be executed by the background workers.
-func (d *DSPIO) Enqueue(in In) {
+// It reports whether the request was queued; rejected requests are still answered on their Responder.
+func (d *DSPIO) Enqueue(in In) bool {
 	d.logger.Info("dspio: enqueued request", slog.Int("dsp_id", in.DSPID), slog.Int("id", in.ID))
 
 	mDSPRequestTotal.
 		WithLabelValues(strconv.Itoa(in.DSPID)).
 		Inc()
 
+	breaker := d.circuitBreaker(in.DSPID)
+	if breaker != nil {
+		gen, ok := breaker.Allow(time.Now())
+		if !ok {
+			mDSPRequestShed.WithLabelValues(strconv.Itoa(in.DSPID)).Inc()
+			in.Responder <- Out{ID: in.ID, DSPID: in.DSPID, Err: errBreakerOpen}
+			return false
+		}
+		in.breakerGen = gen
+	}
+
+	q := d.queue(&in)
+
+	if q.limiter != nil && !q.limiter.TryAcquire() {
+		if breaker != nil {
+			breaker.Release(in.breakerGen)
+		}
+		mDSPRequestLimited.WithLabelValues(strconv.Itoa(in.DSPID)).Inc()
+		in.Responder <- Out{ID: in.ID, DSPID: in.DSPID, Err: errLimitExceeded}
+		return false
+	}
+
 	select {
-	case d.input <- in:
-		return
+	case q.input <- in:
+		q.depth.Set(float64(len(q.input)))
+		return true
 	default:
 	}
 
+	if breaker != nil {
+		breaker.Release(in.breakerGen)
+	}
+	if q.limiter != nil {
+		q.limiter.Cancel()
+	}
+
 	mDSPRequestDropped.
 		WithLabelValues(strconv.Itoa(in.DSPID)).
 		Inc()
@@ -322,6 +431,22 @@ func (d *DSPIO) Enqueue(in In) {
 		DSPID: in.DSPID,
 		Err:   errors.New("dspio: queue is full"),
 	}
+
+	return false
+}
+
+// circuitBreaker returns the circuit breaker of the DSP, or nil when breakers are disabled.
+func (d *DSPIO) circuitBreaker(dspID int) *CircuitBreaker {
+	if !d.breaker.Enabled {
+		return nil
+	}
+
+	if v, ok := d.breakers.Load(dspID); ok {
+		return v.(*CircuitBreaker)
+	}
+
+	v, _ := d.breakers.LoadOrStore(dspID, NewCircuitBreaker(dspID, d.breaker))
+	return v.(*CircuitBreaker)
 }
 
 // Execute executes the DSP request.
@@ -331,23 +456,50 @@ func (d *DSPIO) Execute(in In) {
 
 	d.logger.Info("dspio: executing request", slog.Int("dsp_id", in.DSPID), slog.Int("id", in.ID))
 
-	start := time.Now()
-	res, err := d.transport.RoundTrip(in.BidRequest)
-	elapsed := time.Since(start).Seconds()
 	dspIDStr := strconv.Itoa(in.DSPID)
 
-	hDSPRequestDuration.WithLabelValues(dspIDStr).Observe(elapsed)
-
-	if err != nil {
-		d.logger.Info("dspio: response error", slog.Int("dsp_id", in.DSPID), slog.Int("id", in.ID), slog.Any("error", err))
-		mDSPRequestError.WithLabelValues(dspIDStr).Inc()
-		in.Responder <- Out{ID: in.ID, DSPID: in.DSPID, Err: err}
+	if in.closed() {
+		if q, ok := d.queues.Load(in.DSPID); ok && q.(*dspQueue).limiter != nil {
+			q.(*dspQueue).limiter.Cancel()
+		}
+		if breaker := d.circuitBreaker(in.DSPID); breaker != nil {
+			breaker.Release(in.breakerGen)
+		}
+		mDSPRequestExpired.WithLabelValues(dspIDStr).Inc()
+		in.Responder <- Out{ID: in.ID, DSPID: in.DSPID, Err: errAuctionClosed}
 		return
 	}
 
+	start := time.Now()
 	var bidResponse openrtb.BidResponse
-	if err = json.NewDecoder(res.Body).Decode(&bidResponse); err != nil {
-		d.logger.Info("dspio: response decode error", slog.Int("dsp_id", in.DSPID), slog.Int("id", in.ID), slog.Any("error", err))
+	var err error
+	if in.Hedge {
+		bidResponse, err = d.hedge(in, dspIDStr)
+	} else {
+		bidResponse, err = d.attempt(in.BidRequest.Context(), in, dspIDStr)
+	}
+
+	// Requests of closed auctions say nothing about the DSP health or latency.
+	if q, ok := d.queues.Load(in.DSPID); ok && q.(*dspQueue).limiter != nil {
+		if errors.Is(err, errAuctionClosed) {
+			q.(*dspQueue).limiter.Cancel()
+		} else {
+			q.(*dspQueue).limiter.Release(time.Since(start), err != nil)
+		}
+	}
+
+	if breaker := d.circuitBreaker(in.DSPID); breaker != nil {
+		if errors.Is(err, errAuctionClosed) {
+			breaker.Release(in.breakerGen)
+		} else {
+			breaker.Done(in.breakerGen, err == nil, time.Now())
+		}
+	}
+
+	mDSPResponse.WithLabelValues(dspIDStr, responseOutcome(&bidResponse, err)).Inc()
+
+	if err != nil {
+		d.logger.Info("dspio: response error", slog.Int("dsp_id", in.DSPID), slog.Int("id", in.ID), slog.Any("error", err))
 		mDSPRequestError.WithLabelValues(dspIDStr).Inc()
 		in.Responder <- Out{ID: in.ID, DSPID: in.DSPID, Err: err}
 		return
@@ -369,7 +521,34 @@ func (d *DSPIO) Execute(in In) {
 var rateDSPConcurrency = prometheus.NewGauge(prometheus.GaugeOpts{Name: "dspio_concurrency_rate"})
 var mDSPRequestTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dspio_request_total"}, []string{"dsp_id"})
 var mDSPRequestDropped = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dspio_request_dropped_total"}, []string{"dsp_id"})
+var gDSPQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "dspio_queue_depth"}, []string{"dsp_id"})
+var gDSPWorkers = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "dspio_workers"}, []string{"dsp_id"})
+var hDSPQueueWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "dspio_queue_wait_seconds",
+	Help:    "Time DSP requests wait in their DSP queue before a worker picks them up.",
+	Buckets: prometheus.ExponentialBuckets(0.00001, 2, 18), // 10µs to ~1.3s
+}, []string{"dsp_id"})
+var gDSPConcurrencyLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "dspio_concurrency_limit"}, []string{"dsp_id"})
+var mDSPRequestLimited = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dspio_request_limited_total"}, []string{"dsp_id"})
+var mDSPRequestShed = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dspio_request_shed_total"}, []string{"dsp_id"})
+var gDSPBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "dspio_breaker_state",
+	Help: "Circuit breaker state per DSP: 0 closed, 1 open, 2 half-open.",
+}, []string{"dsp_id"})
+var mDSPBreakerTransition = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dspio_breaker_transition_total"}, []string{"dsp_id", "state"})
+var mDSPRequestHedged = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dspio_request_hedged_total"}, []string{"dsp_id"})
+var mDSPRequestHedgeWon = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dspio_request_hedge_won_total"}, []string{"dsp_id"})
+var mDSPRequestRetry = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dspio_request_retry_total"}, []string{"dsp_id"})
+var mDSPRequestRetrySkipped = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dspio_request_retry_skipped_total"}, []string{"dsp_id"})
+var mDSPRequestThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dspio_request_throttled_total"}, []string{"dsp_id", "reason"})
 var mDSPRequestError = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dspio_request_error_total"}, []string{"dsp_id"})
+var mDSPResponse = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dspio_response_total"}, []string{"dsp_id", "outcome"})
+var mDSPRequestExpired = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dspio_request_expired_total"}, []string{"dsp_id"})
+var hDSPResponseLate = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "dspio_response_late_seconds",
+	Help:    "Time past the auction deadline of DSP responses that arrived after the auction closed.",
+	Buckets: prometheus.ExponentialBuckets(0.0005, 2, 12), // 0.5ms to ~1s
+}, []string{"dsp_id"})
 var mDSPConnDialTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dspio_conn_dial_total"}, []string{"host"})
 var hDSPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 	Name:    "dspio_request_duration_seconds",
@@ -377,13 +556,80 @@ var hDSPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 	Buckets: prometheus.ExponentialBuckets(0.001, 2, 14), // 1ms to ~8s
 }, []string{"dsp_id"})
 
+// Admission control metrics.
+var gAdmissionInFlight = prometheus.NewGauge(prometheus.GaugeOpts{Name: "admission_in_flight"})
+var gAdmissionQueued = prometheus.NewGauge(prometheus.GaugeOpts{Name: "admission_queued"})
+var mAdmissionShed = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "admission_shed_total"}, []string{"reason"})
+var hAdmissionQueueWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "admission_queue_wait_seconds",
+	Help:    "Time admitted ad requests waited in the admission queue.",
+	Buckets: prometheus.ExponentialBuckets(0.00001, 2, 18), // 10µs to ~1.3s
+})
+
 // Ad request metrics.
 var counterTotalAdRequest = prometheus.NewCounter(prometheus.CounterOpts{Name: "ad_request_total"})
 var mTotalAdRequestPerPubAndApp = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ad_request_per_pub_and_app_total"}, []string{"pub_id", "app_id"})
+var mTotalAdRequestPerPubAndSite = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ad_request_per_pub_and_site_total"}, []string{"pub_id", "site_id"})
+var hAdRequestImps = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "ad_request_imps",
+	Help:    "Number of impressions per ad request.",
+	Buckets: prometheus.LinearBuckets(1, 1, 10),
+})
+var mAdRequestInvalid = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ad_request_invalid_total"}, []string{"reason"})
+var mAdRequestNoBid = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ad_request_nobid_total"}, []string{"reason"})
+var hAdRequestBudget = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "ad_request_budget_seconds",
+	Help:    "Effective time budget of ad requests: the smaller of tmax and the configured maximum.",
+	Buckets: prometheus.ExponentialBuckets(0.01, 2, 10), // 10ms to ~5s
+})
+var hAdRequestPhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "ad_request_phase_duration_seconds",
+	Help:    "Time spent on each phase of an ad request.",
+	Buckets: prometheus.ExponentialBuckets(0.00001, 2, 18), // 10µs to ~1.3s
+}, []string{"phase"})
+var hAdRequestPhaseBudget = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "ad_request_phase_budget_ratio",
+	Help:    "Share of the ad request time budget used by each phase.",
+	Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 0.75, 0.9, 1, 1.25},
+}, []string{"phase"})
+var counterFloorCurrencyRejected = prometheus.NewCounter(prometheus.CounterOpts{Name: "ad_request_floor_currency_rejected_total"})
 
 // DSP exchange metrics.
 var mDSPBeforePerPub = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dsp_before_per_pub_total"}, []string{"dsp_id", "pub_id"})
 var mDSPAfterPerPub = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dsp_after_per_pub_total"}, []string{"dsp_id", "pub_id"})
+var mDSPSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dsp_skipped_total"}, []string{"dsp_id", "reason"})
+var hAdRequestDSPEligible = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "ad_request_dsp_eligible",
+	Help:    "Number of DSPs eligible for, and sent, each ad request.",
+	Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+})
+var hAdRequestDSPSkipped = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "ad_request_dsp_skipped",
+	Help:    "Number of DSPs skipped for each ad request by capabilities, targeting or seats.",
+	Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+})
+
+// Notice IO metrics.
+var mNoticeTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "noticeio_request_total"}, []string{"dsp_id", "kind"})
+var mNoticeDropped = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "noticeio_request_dropped_total"}, []string{"dsp_id", "kind"})
+var mNoticeError = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "noticeio_request_error_total"}, []string{"dsp_id", "kind"})
+var mNoticeRetry = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "noticeio_request_retry_total"}, []string{"dsp_id", "kind"})
+var hNoticeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "noticeio_request_duration_seconds",
+	Help:    "Time spent firing a win or billing notice, per attempt.",
+	Buckets: prometheus.ExponentialBuckets(0.001, 2, 12), // 1ms to ~2s
+}, []string{"kind"})
+
+// Auction metrics.
+var mAuctionBidTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "auction_bid_total"}, []string{"dsp_id"})
+var mAuctionWinTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "auction_win_total"}, []string{"dsp_id"})
+var mAuctionDealWinTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "auction_deal_win_total"}, []string{"dsp_id"})
+var mAuctionBidRejected = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "auction_bid_rejected_total"}, []string{"dsp_id", "reason"})
+var hAuctionDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "auction_duration_seconds",
+	Help:    "Time spent picking winners and building the merged bid response.",
+	Buckets: prometheus.ExponentialBuckets(0.000001, 2, 16), // 1µs to ~32ms
+})
 
 // Config info: always-exposed metrics so dashboard variables (e.g. dsp_id) have options before traffic.
 var gDSPConfigInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -399,13 +645,54 @@ func init() {
 		rateDSPConcurrency,
 		mDSPRequestTotal,
 		mDSPRequestDropped,
+		mDSPRequestThrottled,
+		mDSPRequestShed,
+		gDSPConcurrencyLimit,
+		mDSPRequestLimited,
+		gDSPQueueDepth,
+		gDSPWorkers,
+		hDSPQueueWait,
+		gDSPBreakerState,
+		mDSPBreakerTransition,
+		mDSPRequestHedged,
+		mDSPRequestHedgeWon,
+		mDSPRequestRetry,
+		mDSPRequestRetrySkipped,
 		mDSPRequestError,
+		mDSPResponse,
+		mDSPRequestExpired,
+		hDSPResponseLate,
 		mDSPConnDialTotal,
 		hDSPRequestDuration,
+		mNoticeTotal,
+		mNoticeDropped,
+		mNoticeError,
+		mNoticeRetry,
+		hNoticeDuration,
+		gAdmissionInFlight,
+		gAdmissionQueued,
+		mAdmissionShed,
+		hAdmissionQueueWait,
 		counterTotalAdRequest,
 		mTotalAdRequestPerPubAndApp,
+		mTotalAdRequestPerPubAndSite,
+		hAdRequestImps,
+		mAdRequestInvalid,
+		mAdRequestNoBid,
+		hAdRequestBudget,
+		hAdRequestPhaseDuration,
+		hAdRequestPhaseBudget,
+		counterFloorCurrencyRejected,
 		mDSPBeforePerPub,
 		mDSPAfterPerPub,
+		mDSPSkipped,
+		hAdRequestDSPEligible,
+		hAdRequestDSPSkipped,
+		mAuctionBidTotal,
+		mAuctionWinTotal,
+		mAuctionDealWinTotal,
+		mAuctionBidRejected,
+		hAuctionDuration,
 		gDSPConfigInfo,
 	)
 }
@@ -432,9 +719,29 @@ func main() {
 		os.Exit(1)
 	}
 
-	plan := make(map[string]CacheLoadFunc, 2)
+	plan := make(map[string]CacheLoadFunc, 6)
 	plan["apps"] = CacheLoadApps(os.Getenv("EXCHANGE_APPS_CACHE_PATH"), useInternStrings)
 	plan["dsps"] = CacheLoadDSPs(os.Getenv("EXCHANGE_DSPS_CACHE_PATH"), useInternStrings)
+	// Sites are optional; without them only app inventory is served.
+	if path := os.Getenv("EXCHANGE_SITES_CACHE_PATH"); path != "" {
+		plan["sites"] = CacheLoadSites(path, useInternStrings)
+	}
+	// Floor rules are optional; without them only Imp.BidFloor is enforced.
+	if path := os.Getenv("EXCHANGE_FLOORS_CACHE_PATH"); path != "" {
+		plan["floors"] = CacheLoadFloors(path)
+	}
+	// Currency rates are optional; without them only bids and floors in the exchange currency are accepted.
+	if path := os.Getenv("EXCHANGE_CURRENCY_RATES_CACHE_PATH"); path != "" {
+		plan["currency_rates"] = CacheLoadCurrencyRates(path)
+	}
+	// Publisher block lists are optional; without them only the request block lists are enforced.
+	if path := os.Getenv("EXCHANGE_BLOCKLISTS_CACHE_PATH"); path != "" {
+		plan["blocklists"] = CacheLoadBlocklists(path, useInternStrings)
+	}
+	// The deals catalogue is optional; without it only deals sent in the request are offered.
+	if path := os.Getenv("EXCHANGE_DEALS_CACHE_PATH"); path != "" {
+		plan["deals"] = CacheLoadDeals(path, useInternStrings)
+	}
 
 	cache := NewCache(logger, plan)
 	if err := cache.Load(rootCtx); err != nil {
@@ -470,9 +777,15 @@ func main() {
 		logger.Error("main: failed to parse EXCHANGE_DSPIO_TIMEOUT", slog.Any("error", err))
 		os.Exit(1)
 	}
-	pool, err := envvarutil.GetInt("EXCHANGE_DSPIO_POOL", 100)
+	// Each DSP has its own workers and queue; dsps.json may override them per DSP.
+	dspWorkers, err := envvarutil.GetInt("EXCHANGE_DSPIO_DSP_WORKERS", 10)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_DSPIO_DSP_WORKERS", slog.Any("error", err))
+		os.Exit(1)
+	}
+	dspQueue, err := envvarutil.GetInt("EXCHANGE_DSPIO_DSP_QUEUE", 100)
 	if err != nil {
-		logger.Error("main: failed to parse EXCHANGE_DSPIO_POOL", slog.Any("error", err))
+		logger.Error("main: failed to parse EXCHANGE_DSPIO_DSP_QUEUE", slog.Any("error", err))
 		os.Exit(1)
 	}
 
@@ -497,13 +810,27 @@ func main() {
 		logger.Error("main: failed to parse EXCHANGE_DSPIO_FORCE_HTTP2", slog.Any("error", err))
 		os.Exit(1)
 	}
+	// requestTimeout is the maximum budget of an ad request; requests with a smaller tmax get a smaller budget.
 	requestTimeout, err := envvarutil.GetDuration("EXCHANGE_DSPIO_REQUEST_TIMEOUT", 500*time.Millisecond)
 	if err != nil {
 		logger.Error("main: failed to parse EXCHANGE_DSPIO_REQUEST_TIMEOUT", slog.Any("error", err))
 		os.Exit(1)
 	}
+	tmaxSafetyMargin, err := envvarutil.GetDuration("EXCHANGE_TMAX_SAFETY_MARGIN", 50*time.Millisecond)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_TMAX_SAFETY_MARGIN", slog.Any("error", err))
+		os.Exit(1)
+	}
+	// The outbound client is nethttp, http1 or h2c; h2c needs http:// DSP endpoints.
+	clientName := envvarutil.GetString("EXCHANGE_DSPIO_CLIENT", ClientNetHTTP)
+	http1Pipeline, err := envvarutil.GetInt("EXCHANGE_DSPIO_HTTP1_PIPELINE", 1)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_DSPIO_HTTP1_PIPELINE", slog.Any("error", err))
+		os.Exit(1)
+	}
 
 	logger.Info("main: DSP IO transport config",
+		slog.String("client", clientName),
 		slog.Duration("dial_timeout", timeout),
 		slog.Duration("keep_alive", keepAlive),
 		slog.Duration("idle_conn_timeout", idleConnTimeout),
@@ -512,6 +839,7 @@ func main() {
 		slog.Bool("force_http2", forceHTTP2),
 		slog.Bool("insecure_skip_verify", insecureSkipVerify),
 		slog.Duration("request_timeout", requestTimeout),
+		slog.Duration("tmax_safety_margin", tmaxSafetyMargin),
 	)
 
 	dialer := &net.Dialer{Timeout: timeout, KeepAlive: keepAlive}
@@ -536,9 +864,239 @@ func main() {
 			return c, nil
 		},
 	}
-	dspio := NewDSPIO(logger, transport, pool)
+	client, err := NewDoer(clientName, transport, http1Pipeline)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_DSPIO_CLIENT", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	breakerEnabled, err := envvarutil.GetBool("EXCHANGE_DSPIO_BREAKER", true)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_DSPIO_BREAKER", slog.Any("error", err))
+		os.Exit(1)
+	}
+	breakerWindow, err := envvarutil.GetDuration("EXCHANGE_DSPIO_BREAKER_WINDOW", 10*time.Second)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_DSPIO_BREAKER_WINDOW", slog.Any("error", err))
+		os.Exit(1)
+	}
+	breakerMinRequests, err := envvarutil.GetInt("EXCHANGE_DSPIO_BREAKER_MIN_REQUESTS", 20)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_DSPIO_BREAKER_MIN_REQUESTS", slog.Any("error", err))
+		os.Exit(1)
+	}
+	breakerFailureRatio, err := envvarutil.GetFloat("EXCHANGE_DSPIO_BREAKER_FAILURE_RATIO", 0.5)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_DSPIO_BREAKER_FAILURE_RATIO", slog.Any("error", err))
+		os.Exit(1)
+	}
+	breakerOpenTimeout, err := envvarutil.GetDuration("EXCHANGE_DSPIO_BREAKER_OPEN_TIMEOUT", 5*time.Second)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_DSPIO_BREAKER_OPEN_TIMEOUT", slog.Any("error", err))
+		os.Exit(1)
+	}
+	breakerProbes, err := envvarutil.GetInt("EXCHANGE_DSPIO_BREAKER_PROBES", 3)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_DSPIO_BREAKER_PROBES", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	// The static limit only uses the DSP workers; aimd, vegas and gradient adapt the limit of each DSP.
+	limitAlgorithm := envvarutil.GetString("EXCHANGE_DSPIO_LIMIT", LimitStatic)
+	if !isStaticLimit(limitAlgorithm) {
+		if _, err := NewLimitAlgorithm(limitAlgorithm); err != nil {
+			logger.Error("main: failed to parse EXCHANGE_DSPIO_LIMIT", slog.Any("error", err))
+			os.Exit(1)
+		}
+	}
+	limitInitial, err := envvarutil.GetInt("EXCHANGE_DSPIO_LIMIT_INITIAL", 5)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_DSPIO_LIMIT_INITIAL", slog.Any("error", err))
+		os.Exit(1)
+	}
+	limitMin, err := envvarutil.GetInt("EXCHANGE_DSPIO_LIMIT_MIN", 1)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_DSPIO_LIMIT_MIN", slog.Any("error", err))
+		os.Exit(1)
+	}
+	limitMax, err := envvarutil.GetInt("EXCHANGE_DSPIO_LIMIT_MAX", dspWorkers)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_DSPIO_LIMIT_MAX", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	retryMinBudget, err := envvarutil.GetDuration("EXCHANGE_DSPIO_RETRY_MIN_BUDGET", 20*time.Millisecond)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_DSPIO_RETRY_MIN_BUDGET", slog.Any("error", err))
+		os.Exit(1)
+	}
+	// DSP requests are kept open this long past the auction deadline, so late responses can be measured.
+	lateGrace, err := envvarutil.GetDuration("EXCHANGE_DSPIO_LATE_GRACE", 50*time.Millisecond)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_DSPIO_LATE_GRACE", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	dspio := NewDSPIO(logger, client, dspWorkers, dspQueue, BreakerConfig{
+		Enabled:      breakerEnabled,
+		Window:       breakerWindow,
+		MinRequests:  breakerMinRequests,
+		FailureRatio: breakerFailureRatio,
+		OpenTimeout:  breakerOpenTimeout,
+		Probes:       breakerProbes,
+	}, LimitConfig{
+		Algorithm: limitAlgorithm,
+		Initial:   limitInitial,
+		Min:       limitMin,
+		Max:       limitMax,
+	}, retryMinBudget)
 	dspio.Start(rootCtx)
 
+	// Notice IO
+	// --
+	noticePool, err := envvarutil.GetInt("EXCHANGE_NOTICEIO_POOL", 20)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_NOTICEIO_POOL", slog.Any("error", err))
+		os.Exit(1)
+	}
+	noticeQueue, err := envvarutil.GetInt("EXCHANGE_NOTICEIO_QUEUE", 1000)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_NOTICEIO_QUEUE", slog.Any("error", err))
+		os.Exit(1)
+	}
+	noticeRetries, err := envvarutil.GetInt("EXCHANGE_NOTICEIO_RETRIES", 2)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_NOTICEIO_RETRIES", slog.Any("error", err))
+		os.Exit(1)
+	}
+	noticeBackoff, err := envvarutil.GetDuration("EXCHANGE_NOTICEIO_RETRY_BACKOFF", 50*time.Millisecond)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_NOTICEIO_RETRY_BACKOFF", slog.Any("error", err))
+		os.Exit(1)
+	}
+	noticeTimeout, err := envvarutil.GetDuration("EXCHANGE_NOTICEIO_TIMEOUT", 1*time.Second)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_NOTICEIO_TIMEOUT", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	noticeio := NewNoticeIO(logger, transport, noticePool, noticeQueue, noticeRetries, noticeBackoff, noticeTimeout)
+	noticeio.Start(rootCtx)
+
+	// Throttling
+	// --
+	smartThrottling, err := envvarutil.GetBool("EXCHANGE_SMART_THROTTLING", false)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_SMART_THROTTLING", slog.Any("error", err))
+		os.Exit(1)
+	}
+	smartThrottlingWindow, err := envvarutil.GetDuration("EXCHANGE_SMART_THROTTLING_WINDOW", 1*time.Minute)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_SMART_THROTTLING_WINDOW", slog.Any("error", err))
+		os.Exit(1)
+	}
+	smartThrottlingMinBidRate, err := envvarutil.GetFloat("EXCHANGE_SMART_THROTTLING_MIN_BID_RATE", 0.05)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_SMART_THROTTLING_MIN_BID_RATE", slog.Any("error", err))
+		os.Exit(1)
+	}
+	smartThrottlingMinSamples, err := envvarutil.GetInt("EXCHANGE_SMART_THROTTLING_MIN_SAMPLES", 100)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_SMART_THROTTLING_MIN_SAMPLES", slog.Any("error", err))
+		os.Exit(1)
+	}
+	smartThrottlingExplore, err := envvarutil.GetFloat("EXCHANGE_SMART_THROTTLING_EXPLORE", 0.1)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_SMART_THROTTLING_EXPLORE", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	throttler := NewThrottler(SmartThrottlingConfig{
+		Enabled:    smartThrottling,
+		Window:     smartThrottlingWindow,
+		MinBidRate: smartThrottlingMinBidRate,
+		MinSamples: smartThrottlingMinSamples,
+		Explore:    smartThrottlingExplore,
+	})
+
+	// Auction
+	// --
+	secondPriceIncrement, err := envvarutil.GetFloat("EXCHANGE_AUCTION_SECOND_PRICE_INCREMENT", 0.01)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_AUCTION_SECOND_PRICE_INCREMENT", slog.Any("error", err))
+		os.Exit(1)
+	}
+	// No-bids are answered with a 204 by default, or with a 200 carrying BidResponse.NBR.
+	noBidWithNBR, err := envvarutil.GetBool("EXCHANGE_NOBID_NBR", false)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_NOBID_NBR", slog.Any("error", err))
+		os.Exit(1)
+	}
+	auctionConfig := AuctionConfig{
+		Currency:             envvarutil.GetString("EXCHANGE_CURRENCY", DefaultCurrency),
+		SecondPriceIncrement: secondPriceIncrement,
+	}
+
+	// Fan-out
+	// --
+	// The variant is either baseline, optimized or a comma separated list of fan-out stages.
+	fanOutVariant, err := ParseFanOutVariant(envvarutil.GetString("EXCHANGE_FANOUT_VARIANT", FanOutVariantOptimized))
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_FANOUT_VARIANT", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	fanout := NewFanOut(fanOutVariant)
+
+	// Admission control
+	// --
+	admissionMaxInFlight, err := envvarutil.GetInt("EXCHANGE_ADMISSION_MAX_IN_FLIGHT", 0)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_ADMISSION_MAX_IN_FLIGHT", slog.Any("error", err))
+		os.Exit(1)
+	}
+	admissionMaxQueue, err := envvarutil.GetInt("EXCHANGE_ADMISSION_MAX_QUEUE", 100)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_ADMISSION_MAX_QUEUE", slog.Any("error", err))
+		os.Exit(1)
+	}
+	admissionQueueTimeout, err := envvarutil.GetDuration("EXCHANGE_ADMISSION_QUEUE_TIMEOUT", 100*time.Millisecond)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_ADMISSION_QUEUE_TIMEOUT", slog.Any("error", err))
+		os.Exit(1)
+	}
+	admissionCoDel, err := envvarutil.GetBool("EXCHANGE_ADMISSION_CODEL", false)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_ADMISSION_CODEL", slog.Any("error", err))
+		os.Exit(1)
+	}
+	admissionCoDelTarget, err := envvarutil.GetDuration("EXCHANGE_ADMISSION_CODEL_TARGET", 5*time.Millisecond)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_ADMISSION_CODEL_TARGET", slog.Any("error", err))
+		os.Exit(1)
+	}
+	admissionCoDelInterval, err := envvarutil.GetDuration("EXCHANGE_ADMISSION_CODEL_INTERVAL", 100*time.Millisecond)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_ADMISSION_CODEL_INTERVAL", slog.Any("error", err))
+		os.Exit(1)
+	}
+	admissionRetryAfter, err := envvarutil.GetDuration("EXCHANGE_ADMISSION_RETRY_AFTER", 1*time.Second)
+	if err != nil {
+		logger.Error("main: failed to parse EXCHANGE_ADMISSION_RETRY_AFTER", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	// Admission control is disabled unless EXCHANGE_ADMISSION_MAX_IN_FLIGHT is set.
+	admission := NewAdmission(AdmissionConfig{
+		MaxInFlight:   admissionMaxInFlight,
+		MaxQueue:      admissionMaxQueue,
+		QueueTimeout:  admissionQueueTimeout,
+		CoDel:         admissionCoDel,
+		CoDelTarget:   admissionCoDelTarget,
+		CoDelInterval: admissionCoDelInterval,
+		RetryAfter:    admissionRetryAfter,
+	})
+
 	// HTTP endpoints
 	// --
 	// Ping/Pong
@@ -559,131 +1117,13 @@ func main() {
 
 	// Ad request endpoint.
 	// This is the main endpoint that will be used for experimentation.
-	mux.HandleFunc("/ad", func(w http.ResponseWriter, r *http.Request) {
-		counterTotalAdRequest.Inc()
-
-		gz, err := gzip.NewReader(r.Body)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		defer gz.Close()
-
-		var adRequest openrtb.BidRequest
-		if err = json.NewDecoder(gz).Decode(&adRequest); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		apps := cache.state.Apps.Load()
-		appid, err := strconv.Atoi(adRequest.App.ID)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		app := apps.Apps[appid]
-		if app == nil {
-			http.Error(w, "app not found", http.StatusNotFound)
-			return
-		}
-
-		mTotalAdRequestPerPubAndApp.
-			WithLabelValues(strconv.Itoa(app.Publisher.ID), strconv.Itoa(app.ID)).
-			Inc()
-
-		dsps := cache.state.DSPs.Load()
-		responses := make(chan Out, len(dsps.DSPs))
-		ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
-		defer cancel()
-		// Do not close `responses`: DSP IO workers may still send after we return,
-		// and closing here would risk panics ("send on closed channel").
-
-		body, err := json.Marshal(adRequest)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		for i, dsp := range dsps.DSPs {
-			mDSPBeforePerPub.
-				WithLabelValues(strconv.Itoa(dsp.ID), strconv.Itoa(app.Publisher.ID)).
-				Inc()
-
-			buf := new(bytes.Buffer)
-			gzw := gzip.NewWriter(buf)
-			if _, err := gzw.Write(body); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			if err := gzw.Close(); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-
-			bidURL := dsp.Endpoint
-			if dsp.Latency != "" {
-				u, err := url.Parse(dsp.Endpoint)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				q := u.Query()
-				q.Set("latency", dsp.Latency)
-				u.RawQuery = q.Encode()
-				bidURL = u.String()
-			}
-
-			req, err := http.NewRequestWithContext(ctx, http.MethodPost, bidURL, buf)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Content-Encoding", "gzip")
-
-			dspio.Enqueue(In{
-				ID:         i,
-				DSPID:      dsp.ID,
-				BidRequest: req,
-				Responder:  responses,
-				Timestamp:  time.Now(),
-			})
-
-			mDSPAfterPerPub.
-				WithLabelValues(strconv.Itoa(dsp.ID), strconv.Itoa(app.Publisher.ID)).
-				Inc()
-		}
-
-		n := len(dsps.DSPs)
-		bidResponses := make([]Out, 0, n)
-
-	loop:
-		for range n {
-			select {
-			case out := <-responses:
-				if out.Err == nil {
-					bidResponses = append(bidResponses, out)
-				} else {
-					logger.Error("exchange: error from dsp", slog.Int("dsp_id", out.DSPID), slog.Any("error", out.Err))
-				}
-			case <-ctx.Done():
-				break loop
-			}
-		}
-
-		var bidResponse openrtb.BidResponse
-		if len(bidResponses) > 0 {
-			bidResponse = bidResponses[0].BidResponse
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-
-		if err := json.NewEncoder(w).Encode(bidResponse); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	})
+	mux.Handle("/ad", admission.Wrap(NewAdHandler(logger, cache.state, fanout, dspio, noticeio, throttler, AdHandlerConfig{
+		Auction:          auctionConfig,
+		RequestTimeout:   requestTimeout,
+		TMaxSafetyMargin: tmaxSafetyMargin,
+		LateGrace:        lateGrace,
+		NoBidWithNBR:     noBidWithNBR,
+	})))
 
 	// Starting the HTTP server
 	// --
@@ -694,6 +1134,7 @@ func main() {
 
 		cache.Stop()
 		dspio.Stop()
+		noticeio.Stop()
 
 		c, fn := context.WithTimeout(context.Background(), 5*time.Second)
 		defer fn()
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"perftest/libs/openrtb"
+)
+
+// Fan-out
+// The fan-out builds the bid request sent to each DSP, through the DSP adapter if it has one.
+// Its stages can be toggled by the fan-out variant, so the baseline and the optimized paths
+// can be profiled side by side:
+//   - compress_once: the body is gzipped once per auction and shared read-only by the DSP requests without adapter.
+//   - pool: gzip writers and readers are reused through a sync.Pool.
+//   - precomputed_url: the DSP URL, with its latency query, is built when the DSP cache loads.
+// --
+
+// Fan-out stages.
+const (
+	FanOutStageCompressOnce  = "compress_once"
+	FanOutStagePool          = "pool"
+	FanOutStagePrecomputeURL = "precomputed_url"
+)
+
+// Fan-out variants that stand for no stage and for every stage.
+const (
+	FanOutVariantBaseline  = "baseline"
+	FanOutVariantOptimized = "optimized"
+)
+
+// FanOutVariant selects the stages of the fan-out.
+type FanOutVariant struct {
+	CompressOnce  bool
+	Pool          bool
+	PrecomputeURL bool
+}
+
+// ParseFanOutVariant parses a comma separated list of fan-out stages, or one of the baseline and optimized variants.
+func ParseFanOutVariant(s string) (FanOutVariant, error) {
+	switch s {
+	case FanOutVariantBaseline, "":
+		return FanOutVariant{}, nil
+	case FanOutVariantOptimized:
+		return FanOutVariant{CompressOnce: true, Pool: true, PrecomputeURL: true}, nil
+	}
+
+	var variant FanOutVariant
+	for stage := range strings.SplitSeq(s, ",") {
+		switch strings.TrimSpace(stage) {
+		case FanOutStageCompressOnce:
+			variant.CompressOnce = true
+		case FanOutStagePool:
+			variant.Pool = true
+		case FanOutStagePrecomputeURL:
+			variant.PrecomputeURL = true
+		default:
+			return FanOutVariant{}, fmt.Errorf("fanout: unknown stage %q", stage)
+		}
+	}
+
+	return variant, nil
+}
+
+// FanOutBody is the bid request of an auction.
+// Compressed is set only when the body is compressed once for every DSP; it must not be modified.
+type FanOutBody struct {
+	Request    *openrtb.BidRequest
+	Inventory  *Inventory
+	Raw        []byte
+	Compressed []byte
+}
+
+// FanOut builds the DSP requests of the auctions. It is safe for concurrent use.
+type FanOut struct {
+	variant FanOutVariant
+	writers sync.Pool
+	readers sync.Pool
+}
+
+// NewFanOut creates a new fan-out with the given variant.
+func NewFanOut(variant FanOutVariant) *FanOut {
+	return &FanOut{
+		variant: variant,
+		writers: sync.Pool{New: func() any { return gzip.NewWriter(nil) }},
+	}
+}
+
+// Body marshals the request of an auction, compressing it when the compress_once stage is enabled.
+// The request must not be modified while its DSP requests are built.
+func (f *FanOut) Body(request *openrtb.BidRequest, inv *Inventory) (FanOutBody, error) {
+	raw, err := json.Marshal(request)
+	if err != nil {
+		return FanOutBody{}, err
+	}
+
+	body := FanOutBody{Request: request, Inventory: inv, Raw: raw}
+	if !f.variant.CompressOnce {
+		return body, nil
+	}
+
+	compressed, err := f.compress(CompressionGzip, raw)
+	if err != nil {
+		return FanOutBody{}, err
+	}
+	body.Compressed = compressed
+
+	return body, nil
+}
+
+// NewRequest creates the bid request of a DSP.
+// The request body is built by the DSP adapter when it changes the request, or else is the shared body:
+// the copy compressed once, when the DSP takes gzip, or the raw body compressed for this DSP only.
+func (f *FanOut) NewRequest(ctx context.Context, dsp *DSP, body FanOutBody) (*http.Request, error) {
+	raw, shared := body.Raw, true
+	if dsp.adapter != nil {
+		b, err := dsp.adapter.MakeRequest(body.Request, body.Inventory)
+		if err != nil {
+			return nil, err
+		}
+		if b != nil {
+			raw, shared = b, false
+		}
+	}
+
+	compression := CompressionGzip
+	if dsp.Encoding != nil && dsp.Encoding.Compression != "" {
+		compression = dsp.Encoding.Compression
+	}
+
+	encoded := body.Compressed
+	if !shared || compression != CompressionGzip || encoded == nil {
+		var err error
+		if encoded, err = f.compress(compression, raw); err != nil {
+			return nil, err
+		}
+	}
+
+	bidURL := dsp.URL
+	if !f.variant.PrecomputeURL || bidURL == "" {
+		var err error
+		if bidURL, err = dspURL(dsp.Endpoint, dsp.Latency); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, bidURL, bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+	setEncodingHeaders(req.Header, dsp.Encoding)
+
+	return req, nil
+}
+
+// NewReader returns a gzip reader of r. Readers must be given back with PutReader once read.
+func (f *FanOut) NewReader(r io.Reader) (*gzip.Reader, error) {
+	if !f.variant.Pool {
+		return gzip.NewReader(r)
+	}
+
+	if gz, ok := f.readers.Get().(*gzip.Reader); ok {
+		if err := gz.Reset(r); err != nil {
+			f.readers.Put(gz)
+			return nil, err
+		}
+		return gz, nil
+	}
+
+	return gzip.NewReader(r)
+}
+
+// PutReader closes the reader and, with the pool stage enabled, gives it back to the pool.
+func (f *FanOut) PutReader(gz *gzip.Reader) {
+	gz.Close()
+	if f.variant.Pool {
+		f.readers.Put(gz)
+	}
+}
+
+// compress compresses b into a new buffer, which is never reused, so it can be shared by in-flight requests.
+func (f *FanOut) compress(compression string, b []byte) ([]byte, error) {
+	if compression == CompressionNone {
+		return b, nil
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(b)/2))
+
+	var w io.WriteCloser
+	switch compression {
+	case CompressionGzip:
+		if f.variant.Pool {
+			gzw := f.writers.Get().(*gzip.Writer)
+			gzw.Reset(buf)
+			defer f.writers.Put(gzw)
+			w = gzw
+		} else {
+			w = gzip.NewWriter(buf)
+		}
+	default:
+		compressor, ok := compressors[compression]
+		if !ok {
+			return nil, fmt.Errorf("fanout: unsupported compression %q", compression)
+		}
+		var err error
+		if w, err = compressor(buf); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// dspURL returns the endpoint of a DSP with the latency query the test DSPs answer with, if any.
+func dspURL(endpoint, latency string) (string, error) {
+	if latency == "" {
+		return endpoint, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("latency", latency)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
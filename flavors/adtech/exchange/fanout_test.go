@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"perftest/libs/openrtb"
+)
+
+func TestParseFanOutVariant(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    FanOutVariant
+		wantErr bool
+	}{
+		{in: "", want: FanOutVariant{}},
+		{in: FanOutVariantBaseline, want: FanOutVariant{}},
+		{in: FanOutVariantOptimized, want: FanOutVariant{CompressOnce: true, Pool: true, PrecomputeURL: true}},
+		{in: "compress_once, precomputed_url", want: FanOutVariant{CompressOnce: true, PrecomputeURL: true}},
+		{in: "pool", want: FanOutVariant{Pool: true}},
+		{in: "pool,unknown", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseFanOutVariant(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFanOut_NewRequest(t *testing.T) {
+	request := &openrtb.BidRequest{ID: "req-1", Imp: []openrtb.Imp{{ID: "1"}}}
+	raw, _ := json.Marshal(request)
+	dsp := &DSP{ID: 1, Endpoint: "http://dsp-1:8080/bid", Latency: "10ms"}
+	dsp.URL, _ = dspURL(dsp.Endpoint, dsp.Latency)
+
+	for _, name := range []string{FanOutVariantBaseline, FanOutVariantOptimized, "pool", "compress_once"} {
+		t.Run(name, func(t *testing.T) {
+			variant, _ := ParseFanOutVariant(name)
+			fanout := NewFanOut(variant)
+
+			body, err := fanout.Body(request, newTestAppInventory(1, 10))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if variant.CompressOnce != (body.Compressed != nil) {
+				t.Errorf("compressed = %v; want %v", body.Compressed != nil, variant.CompressOnce)
+			}
+
+			// Every request, including the ones rebuilt from GetBody by hedges and retries, reads the same body.
+			for range 2 {
+				req, err := fanout.NewRequest(context.Background(), dsp, body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := req.URL.String(), "http://dsp-1:8080/bid?latency=10ms"; got != want {
+					t.Errorf("url = %s; want %s", got, want)
+				}
+				if got := req.Header.Get("Content-Encoding"); got != "gzip" {
+					t.Errorf("Content-Encoding = %q; want gzip", got)
+				}
+
+				for _, rc := range []func() (io.ReadCloser, error){
+					func() (io.ReadCloser, error) { return req.Body, nil },
+					req.GetBody,
+				} {
+					r, err := rc()
+					if err != nil {
+						t.Fatal(err)
+					}
+					gz, err := fanout.NewReader(r)
+					if err != nil {
+						t.Fatal(err)
+					}
+					got, err := io.ReadAll(gz)
+					fanout.PutReader(gz)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, raw) {
+						t.Errorf("body = %s; want %s", got, raw)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFanOut_NewReaderInvalid(t *testing.T) {
+	fanout := NewFanOut(FanOutVariant{Pool: true})
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	gzw.Write([]byte("ok"))
+	gzw.Close()
+
+	gz, err := fanout.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fanout.PutReader(gz)
+
+	// A pooled reader reset on an invalid body must fail like a fresh one.
+	if _, err := fanout.NewReader(bytes.NewReader([]byte("not gzip"))); err == nil {
+		t.Error("expected an error for a body that is not gzipped")
+	}
+}
+
+func TestDSPURL(t *testing.T) {
+	got, err := dspURL("http://dsp-1:8080/bid?x=1", "")
+	if err != nil || got != "http://dsp-1:8080/bid?x=1" {
+		t.Errorf("without latency = %q, %v", got, err)
+	}
+
+	got, err = dspURL("http://dsp-1:8080/bid?x=1", "5ms")
+	if err != nil || got != "http://dsp-1:8080/bid?latency=5ms&x=1" {
+		t.Errorf("with latency = %q, %v", got, err)
+	}
+
+	if _, err = dspURL("http://dsp-1:8080/%zz", "5ms"); err == nil {
+		t.Error("expected an error for an invalid endpoint")
+	}
+}
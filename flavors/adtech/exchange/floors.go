@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+
+	"perftest/libs/openrtb"
+)
+
+// Floors
+// Floor rules raise the minimum price of an impression above what the publisher sent in Imp.BidFloor.
+// Rules are keyed by publisher ID, app ID or banner size. When several rules match, the highest floor wins.
+// Site inventory is only matched by publisher and size rules.
+// --
+
+// FloorRule represents a single floor rule.
+// Exactly one of PublisherID, AppID or W/H is expected to be set.
+type FloorRule struct {
+	PublisherID int     `json:"publisher_id,omitempty"`
+	AppID       int     `json:"app_id,omitempty"`
+	W           int     `json:"w,omitempty"`
+	H           int     `json:"h,omitempty"`
+	Floor       float64 `json:"floor"`
+}
+
+// BannerSize represents a banner width and height pair.
+type BannerSize struct {
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// Floors holds the floor rules indexed for quick lookup.
+type Floors struct {
+	ByPublisher map[int]float64
+	ByApp       map[int]float64
+	BySize      map[BannerSize]float64
+}
+
+// Floor returns the effective floor for the impression.
+// It is the highest of Imp.BidFloor and every matching rule.
+// A nil Floors returns Imp.BidFloor.
+func (f *Floors) Floor(inv *Inventory, imp *openrtb.Imp) float64 {
+	floor := imp.BidFloor
+	if f == nil {
+		return floor
+	}
+
+	if inv != nil {
+		if inv.Publisher != nil {
+			if v, ok := f.ByPublisher[inv.Publisher.ID]; ok && v > floor {
+				floor = v
+			}
+		}
+		if inv.App != nil {
+			if v, ok := f.ByApp[inv.App.ID]; ok && v > floor {
+				floor = v
+			}
+		}
+	}
+
+	if imp.Banner != nil {
+		if v, ok := f.BySize[BannerSize{W: imp.Banner.W, H: imp.Banner.H}]; ok && v > floor {
+			floor = v
+		}
+	}
+
+	return floor
+}
+
+// Apply sets the effective floor of every impression in the request.
+// The updated floors are sent to the DSPs and enforced by the auction.
+func (f *Floors) Apply(inv *Inventory, request *openrtb.BidRequest) {
+	for i := range request.Imp {
+		imp := &request.Imp[i]
+		imp.BidFloor = f.Floor(inv, imp)
+	}
+}
+
+// CacheLoadFloors loads the floor rules from the given path.
+func CacheLoadFloors(path string) CacheLoadFunc {
+	return func(state *State, logger *slog.Logger) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		var decoded []FloorRule
+		if err = json.NewDecoder(f).Decode(&decoded); err != nil {
+			return err
+		}
+
+		floors := &Floors{
+			ByPublisher: make(map[int]float64),
+			ByApp:       make(map[int]float64),
+			BySize:      make(map[BannerSize]float64),
+		}
+		for _, rule := range decoded {
+			switch {
+			case rule.PublisherID != 0:
+				floors.ByPublisher[rule.PublisherID] = rule.Floor
+			case rule.AppID != 0:
+				floors.ByApp[rule.AppID] = rule.Floor
+			case rule.W != 0 || rule.H != 0:
+				floors.BySize[BannerSize{W: rule.W, H: rule.H}] = rule.Floor
+			default:
+				logger.Warn("cache: floor rule without publisher, app or size", slog.Float64("floor", rule.Floor))
+			}
+		}
+
+		state.Floors.Store(floors)
+
+		logger.Info("cache: loaded floors", slog.Int("count", len(decoded)))
+
+		return nil
+	}
+}
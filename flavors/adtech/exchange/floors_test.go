@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"perftest/libs/openrtb"
+)
+
+func TestFloors_Floor(t *testing.T) {
+	floors := &Floors{
+		ByPublisher: map[int]float64{10: 0.5},
+		ByApp:       map[int]float64{100: 1.5},
+		BySize:      map[BannerSize]float64{{W: 300, H: 250}: 0.75},
+	}
+	app := newTestAppInventory(100, 10)
+	other := newTestAppInventory(200, 10)
+
+	tests := []struct {
+		name string
+		inv  *Inventory
+		imp  openrtb.Imp
+		want float64
+	}{
+		{"app rule wins", app, openrtb.Imp{BidFloor: 0.1}, 1.5},
+		{"imp floor wins", app, openrtb.Imp{BidFloor: 2}, 2},
+		{"publisher rule", other, openrtb.Imp{}, 0.5},
+		{"size rule", other, openrtb.Imp{Banner: &openrtb.Banner{W: 300, H: 250}}, 0.75},
+		{"site ignores app rules", newTestSiteInventory(100, 10), openrtb.Imp{}, 0.5},
+		{"no inventory", nil, openrtb.Imp{BidFloor: 0.2}, 0.2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := floors.Floor(tt.inv, &tt.imp); got != tt.want {
+				t.Errorf("Floor() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFloors_NilReturnsImpFloor(t *testing.T) {
+	var floors *Floors
+	imp := openrtb.Imp{BidFloor: 0.3}
+	if got := floors.Floor(newTestAppInventory(1, 0), &imp); got != 0.3 {
+		t.Errorf("Floor() = %v; want 0.3", got)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"perftest/libs/openrtb"
+)
+
+// Ad request handler
+// The /ad handler decodes the bid request of the publisher, enriches it with the inventory, deals, floors
+// and blocklists of the cached state, fans it out to the eligible DSPs and answers with the auction result.
+// DSP requests go through the DSP IO, and the notices of the winners through the notice IO.
+// --
+
+// AdHandlerConfig configures the ad request handler.
+type AdHandlerConfig struct {
+	Auction AuctionConfig
+	// RequestTimeout is the maximum budget of an ad request; requests with a smaller tmax get a smaller budget.
+	RequestTimeout   time.Duration
+	TMaxSafetyMargin time.Duration
+	// LateGrace is how long DSP requests outlive the auction, so late responses can be measured.
+	LateGrace time.Duration
+	// NoBidWithNBR answers no-bids with a 200 carrying BidResponse.NBR instead of a 204.
+	NoBidWithNBR bool
+}
+
+// AdHandler serves the ad requests.
+type AdHandler struct {
+	logger    *slog.Logger
+	state     *State
+	fanout    *FanOut
+	dspio     *DSPIO
+	noticeio  *NoticeIO
+	throttler *Throttler
+	config    AdHandlerConfig
+}
+
+// NewAdHandler creates a new ad request handler reading the cached state.
+func NewAdHandler(logger *slog.Logger, state *State, fanout *FanOut, dspio *DSPIO, noticeio *NoticeIO, throttler *Throttler, config AdHandlerConfig) *AdHandler {
+	return &AdHandler{
+		logger:    logger,
+		state:     state,
+		fanout:    fanout,
+		dspio:     dspio,
+		noticeio:  noticeio,
+		throttler: throttler,
+		config:    config,
+	}
+}
+
+// ServeHTTP runs the auction of the ad request.
+func (h *AdHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The budget starts when the request arrived, including the time it waited for admission.
+	start := requestArrival(r)
+	counterTotalAdRequest.Inc()
+
+	gz, err := h.fanout.NewReader(r.Body)
+	if err != nil {
+		writeInvalidRequest(w, InvalidEncoding, err)
+		return
+	}
+	defer h.fanout.PutReader(gz)
+
+	var adRequest openrtb.BidRequest
+	if err = json.NewDecoder(gz).Decode(&adRequest); err != nil {
+		writeInvalidRequest(w, InvalidJSON, err)
+		return
+	}
+	if err = adRequest.Validate(); err != nil {
+		writeInvalidRequest(w, "", err)
+		return
+	}
+
+	budget := NewBudget(start, adRequest.TMax, h.config.RequestTimeout, h.config.TMaxSafetyMargin)
+	hAdRequestBudget.Observe(budget.Total().Seconds())
+	budget.Mark(PhaseDecode)
+
+	inv, err := resolveInventory(h.state, &adRequest)
+	if err != nil {
+		writeInvalidRequest(w, InvalidInventoryID, err)
+		return
+	}
+	if inv == nil {
+		writeNoBid(w, adRequest.ID, NBRUnknownInventory, h.config.NoBidWithNBR)
+		return
+	}
+
+	pubID := inv.PublisherID()
+	pubIDStr := strconv.Itoa(pubID)
+	switch inv.Type {
+	case InventoryApp:
+		mTotalAdRequestPerPubAndApp.WithLabelValues(pubIDStr, strconv.Itoa(inv.ID)).Inc()
+	case InventorySite:
+		mTotalAdRequestPerPubAndSite.WithLabelValues(pubIDStr, strconv.Itoa(inv.ID)).Inc()
+	}
+	hAdRequestImps.Observe(float64(len(adRequest.Imp)))
+
+	h.state.Deals.Load().Apply(inv, &adRequest)
+
+	rates := h.state.CurrencyRates.Load()
+	if err := rates.NormalizeFloors(&adRequest, h.config.Auction.Currency); err != nil {
+		counterFloorCurrencyRejected.Inc()
+		writeInvalidRequest(w, InvalidFloorCurrency, err)
+		return
+	}
+	h.state.Floors.Load().Apply(inv, &adRequest)
+	h.state.Blocklists.Load().Apply(inv, &adRequest)
+
+	dsps := h.state.DSPs.Load()
+	responses := make(chan Out, len(dsps.DSPs))
+	enqueued := 0
+	ctx, cancel := context.WithDeadline(r.Context(), budget.Deadline())
+	defer cancel()
+	// Do not close `responses`: DSP IO workers may still send after we return,
+	// and closing here would risk panics ("send on closed channel").
+
+	// DSP requests outlive the auction by the late grace, and are canceled once it is over.
+	dspCtx, dspCancel := context.WithDeadline(context.WithoutCancel(r.Context()), budget.Deadline().Add(h.config.LateGrace))
+	defer func() { time.AfterFunc(h.config.LateGrace, dspCancel) }()
+
+	// DSPs get what is left of the budget, so they can answer before the exchange stops waiting.
+	// A tmax of 0 means no limit to the DSPs, so a spent budget is a no-bid instead.
+	adRequest.TMax = int(budget.Remaining().Milliseconds())
+	budget.Mark(PhasePrepare)
+	if adRequest.TMax <= 0 {
+		writeNoBid(w, adRequest.ID, NBRTimeout, h.config.NoBidWithNBR)
+		return
+	}
+
+	body, err := h.fanout.Body(&adRequest, inv)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	skipped := 0
+	for i, dsp := range dsps.DSPs {
+		if reason := dspSkipReason(&adRequest, inv, dsp); reason != "" {
+			mDSPSkipped.WithLabelValues(strconv.Itoa(dsp.ID), reason).Inc()
+			skipped++
+			continue
+		}
+		if reason := h.throttler.Allow(dsp, pubID, time.Now()); reason != "" {
+			mDSPRequestThrottled.WithLabelValues(strconv.Itoa(dsp.ID), reason).Inc()
+			continue
+		}
+
+		mDSPBeforePerPub.
+			WithLabelValues(strconv.Itoa(dsp.ID), pubIDStr).
+			Inc()
+
+		req, err := h.fanout.NewRequest(dspCtx, dsp, body)
+		if err != nil {
+			h.throttler.Refund(dsp)
+			h.logger.Error("exchange: failed to build dsp request", slog.Int("dsp_id", dsp.ID), slog.Any("error", err))
+			mDSPSkipped.WithLabelValues(strconv.Itoa(dsp.ID), SkipReasonAdapter).Inc()
+			skipped++
+			continue
+		}
+
+		queued := h.dspio.Enqueue(In{
+			ID:         i,
+			DSPID:      dsp.ID,
+			BidRequest: req,
+			Responder:  responses,
+			Timestamp:  time.Now(),
+			Hedge:      dsp.Hedge,
+			Retries:    dsp.Retries,
+			Workers:    dsp.Workers,
+			Queue:      dsp.Queue,
+			Deadline:   budget.Deadline(),
+			Closed:     ctx.Done(),
+		})
+		enqueued++
+		// Requests rejected by the DSP IO are not sent, so they neither use the QPS nor count for the bid rate.
+		if queued {
+			h.throttler.RecordRequest(dsp.ID, pubID, time.Now())
+		} else {
+			h.throttler.Refund(dsp)
+		}
+
+		mDSPAfterPerPub.
+			WithLabelValues(strconv.Itoa(dsp.ID), pubIDStr).
+			Inc()
+	}
+
+	hAdRequestDSPEligible.Observe(float64(enqueued))
+	hAdRequestDSPSkipped.Observe(float64(skipped))
+	budget.Mark(PhaseFanOut)
+
+	auction := NewAuction(&adRequest, h.config.Auction, rates, dsps)
+	answered, failed, timedOut := 0, 0, 0
+
+loop:
+	for range enqueued {
+		select {
+		case out := <-responses:
+			if out.Err == nil {
+				answered++
+				if hasBids(&out.BidResponse) {
+					h.throttler.RecordBid(out.DSPID, pubID, time.Now())
+				}
+				auction.Add(&out)
+			} else {
+				failed++
+				if errors.Is(out.Err, context.DeadlineExceeded) {
+					timedOut++
+				}
+				h.logger.Error("exchange: error from dsp", slog.Int("dsp_id", out.DSPID), slog.Any("error", out.Err))
+			}
+		case <-ctx.Done():
+			break loop
+		}
+	}
+
+	budget.Mark(PhaseWait)
+
+	auctionStart := time.Now()
+	bidResponse := auction.Run()
+	hAuctionDuration.Observe(time.Since(auctionStart).Seconds())
+
+	// Without a render event, winners are billed at auction time along with their win notice.
+	winners := auction.Winners()
+	for i := range winners {
+		h.noticeio.Notify(adRequest.ID, &winners[i])
+	}
+
+	budget.Mark(PhaseAuction)
+
+	if len(winners) == 0 {
+		var nbr int
+		switch {
+		case enqueued == 0:
+			nbr = NBRNoEligibleDSP
+		case answered == 0 && (ctx.Err() != nil || timedOut == failed):
+			nbr = NBRTimeout
+		case answered == 0:
+			nbr = openrtb.NBRTechnicalError
+		default:
+			nbr = auction.NoBidReason()
+		}
+
+		writeNoBid(w, adRequest.ID, nbr, h.config.NoBidWithNBR)
+		budget.Mark(PhaseEncode)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(bidResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	budget.Mark(PhaseEncode)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"perftest/libs/openrtb"
+)
+
+// stubDoer answers the DSP requests in place of an HTTP client.
+type stubDoer func(req *http.Request) (*http.Response, error)
+
+func (f stubDoer) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// newTestAdHandler returns an ad handler for app 1 of publisher 10, with a single DSP answered by doer.
+func newTestAdHandler(t *testing.T, doer Doer) *AdHandler {
+	logger := slog.New(slog.DiscardHandler)
+
+	dsp := &DSP{ID: 1, Seats: []string{"1"}, URL: "http://dsp/bid"}
+	state := &State{}
+	state.Apps.Store(&Apps{Apps: map[int]*App{1: {ID: 1, Publisher: &Publisher{ID: 10}}}})
+	state.DSPs.Store(&DSPs{DSPs: []*DSP{dsp}, ByID: map[int]*DSP{dsp.ID: dsp}})
+
+	dspio := NewDSPIO(logger, doer, 1, 1, BreakerConfig{}, LimitConfig{}, 0)
+	dspio.Start(t.Context())
+	t.Cleanup(dspio.Stop)
+
+	return NewAdHandler(logger, state, NewFanOut(FanOutVariant{}), dspio,
+		NewNoticeIO(logger, http.DefaultTransport, 1, 1, 0, 0, time.Second), NewThrottler(SmartThrottlingConfig{}),
+		AdHandlerConfig{
+			Auction:          AuctionConfig{Currency: DefaultCurrency, SecondPriceIncrement: 0.01},
+			RequestTimeout:   time.Second,
+			TMaxSafetyMargin: 5 * time.Millisecond,
+			LateGrace:        time.Second,
+			NoBidWithNBR:     true,
+		})
+}
+
+func newTestAdRequest(t *testing.T, tmax int) *http.Request {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	json.NewEncoder(gz).Encode(openrtb.BidRequest{
+		ID:   "r1",
+		TMax: tmax,
+		Imp:  []openrtb.Imp{{ID: "1", Banner: &openrtb.Banner{W: 300, H: 250}}},
+		App:  &openrtb.App{ID: "1", Publisher: &openrtb.Publisher{ID: "10"}},
+	})
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/ad", &body)
+	req.Header.Set("Content-Encoding", "gzip")
+	return req
+}
+
+func dspResponse(status int, body string) *http.Response {
+	return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+func TestAdHandler(t *testing.T) {
+	const bid = `{"id":"r1","seatbid":[{"seat":"1","bid":[{"id":"b1","impid":"1","price":1.5}]}]}`
+
+	tests := []struct {
+		name    string
+		doer    stubDoer
+		wantNBR int
+		wantBid string
+	}{
+		{
+			name:    "no-bid",
+			doer:    func(*http.Request) (*http.Response, error) { return dspResponse(http.StatusNoContent, ""), nil },
+			wantNBR: NBRNoBids,
+		},
+		{
+			name:    "single winner",
+			doer:    func(*http.Request) (*http.Response, error) { return dspResponse(http.StatusOK, bid), nil },
+			wantBid: "b1",
+		},
+		{
+			// The DSP answers within the late grace but after the auction closed, so its bid is not used.
+			name: "closed auction",
+			doer: func(*http.Request) (*http.Response, error) {
+				time.Sleep(100 * time.Millisecond)
+				return dspResponse(http.StatusOK, bid), nil
+			},
+			wantNBR: NBRTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newTestAdHandler(t, tt.doer)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, newTestAdRequest(t, 50))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d; want 200: %s", w.Code, w.Body)
+			}
+			var res openrtb.BidResponse
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			if res.ID != "r1" || res.NBR != tt.wantNBR {
+				t.Errorf("response id %q, nbr %d; want r1, %d", res.ID, res.NBR, tt.wantNBR)
+			}
+
+			var bids []string
+			for _, seatBid := range res.SeatBid {
+				for _, b := range seatBid.Bid {
+					bids = append(bids, b.ID)
+				}
+			}
+			if want := tt.wantBid; (want == "" && len(bids) > 0) || (want != "" && (len(bids) != 1 || bids[0] != want)) {
+				t.Errorf("bids = %v; want %q", bids, want)
+			}
+		})
+	}
+}
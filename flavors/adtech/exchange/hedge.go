@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"perftest/libs/openrtb"
+)
+
+// Hedging and retries
+// DSPs may opt in to hedged requests: when a DSP has not answered after the p95 latency observed so far,
+// a duplicate request is sent, the first answer wins and the other request is cancelled.
+// DSPs may also opt in to retries on connection-level errors. A retry is only sent when the remaining
+// budget of the ad request covers the DSP p95 latency, or the configured minimum when it is still unknown.
+// The budget runs to the auction deadline, not the late grace of the DSP context: no retry is sent
+// for a closed auction.
+// --
+
+// Latency buckets of the tracker: 1ms growing by 10% per bucket, up to ~1min.
+const (
+	latencyBuckets    = 116
+	latencyBucketBase = float64(time.Millisecond)
+	latencyBucketStep = 1.1
+	// latencyMinSamples is the number of samples needed before quantiles are reported.
+	latencyMinSamples = 20
+)
+
+// LatencyTracker tracks the latency distribution of a DSP in logarithmic buckets.
+// It is safe for concurrent use and does not allocate when observing.
+type LatencyTracker struct {
+	count   atomic.Int64
+	buckets [latencyBuckets]atomic.Int64
+}
+
+// Observe records a latency.
+func (t *LatencyTracker) Observe(latency time.Duration) {
+	i := 0
+	if latency > time.Millisecond {
+		i = min(int(math.Ceil(math.Log(float64(latency)/latencyBucketBase)/math.Log(latencyBucketStep))), latencyBuckets-1)
+	}
+
+	t.buckets[i].Add(1)
+	t.count.Add(1)
+}
+
+// Quantile returns the upper bound of the bucket holding the q quantile.
+// It reports false until enough latencies were observed.
+func (t *LatencyTracker) Quantile(q float64) (time.Duration, bool) {
+	count := t.count.Load()
+	if count < latencyMinSamples {
+		return 0, false
+	}
+
+	rank := int64(math.Ceil(q * float64(count)))
+	var seen int64
+	for i := range t.buckets {
+		seen += t.buckets[i].Load()
+		if seen >= rank {
+			return time.Duration(latencyBucketBase * math.Pow(latencyBucketStep, float64(i))), true
+		}
+	}
+
+	return time.Duration(latencyBucketBase * math.Pow(latencyBucketStep, latencyBuckets-1)), true
+}
+
+// latencyTracker returns the latency tracker of the DSP.
+func (d *DSPIO) latencyTracker(dspID int) *LatencyTracker {
+	if v, ok := d.latencies.Load(dspID); ok {
+		return v.(*LatencyTracker)
+	}
+
+	v, _ := d.latencies.LoadOrStore(dspID, &LatencyTracker{})
+	return v.(*LatencyTracker)
+}
+
+type attemptResult struct {
+	bidResponse openrtb.BidResponse
+	err         error
+	hedge       bool
+}
+
+// hedge sends the request and, if it has not been answered after the DSP p95 latency, a duplicate.
+// The first successful answer is returned; an error is only returned when every request failed.
+func (d *DSPIO) hedge(in In, dspIDStr string) (openrtb.BidResponse, error) {
+	delay, ok := d.latencyTracker(in.DSPID).Quantile(0.95)
+	if !ok {
+		return d.attempt(in.BidRequest.Context(), in, dspIDStr)
+	}
+
+	ctx, cancel := context.WithCancel(in.BidRequest.Context())
+	defer cancel()
+
+	results := make(chan attemptResult, 2)
+	send := func(hedge bool) {
+		bidResponse, err := d.attempt(ctx, in, dspIDStr)
+		results <- attemptResult{bidResponse: bidResponse, err: err, hedge: hedge}
+	}
+
+	go send(false)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	pending := 1
+	select {
+	case res := <-results:
+		return res.bidResponse, res.err
+	case <-timer.C:
+		mDSPRequestHedged.WithLabelValues(dspIDStr).Inc()
+		go send(true)
+		pending++
+	}
+
+	var res attemptResult
+	for range pending {
+		res = <-results
+		if res.err == nil {
+			if res.hedge {
+				mDSPRequestHedgeWon.WithLabelValues(dspIDStr).Inc()
+			}
+			return res.bidResponse, nil
+		}
+	}
+
+	return res.bidResponse, res.err
+}
+
+// attempt sends the request, retrying connection-level errors while the DSP allows it and the budget covers it.
+// The request is cloned with a fresh body unless it is sent as is, under its own context, for the first time.
+func (d *DSPIO) attempt(ctx context.Context, in In, dspIDStr string) (openrtb.BidResponse, error) {
+	for retry := 0; ; retry++ {
+		req := in.BidRequest
+		if retry > 0 || ctx != req.Context() {
+			var err error
+			if req, err = cloneRequest(ctx, in.BidRequest); err != nil {
+				return openrtb.BidResponse{}, err
+			}
+		}
+
+		start := time.Now()
+		res, err := d.client.Do(req)
+		elapsed := time.Since(start)
+
+		hDSPRequestDuration.WithLabelValues(dspIDStr).Observe(elapsed.Seconds())
+
+		if err == nil {
+			if in.closed() {
+				discardLateResponse(&in, res, dspIDStr)
+				return openrtb.BidResponse{}, errAuctionClosed
+			}
+
+			bidResponse, err := readResponse(res)
+			if err == nil {
+				d.latencyTracker(in.DSPID).Observe(time.Since(start))
+			}
+			return bidResponse, err
+		}
+
+		if retry >= in.Retries || !isConnError(err) {
+			return openrtb.BidResponse{}, err
+		}
+		if in.closed() {
+			return openrtb.BidResponse{}, errAuctionClosed
+		}
+		if !d.retryBudget(&in) {
+			mDSPRequestRetrySkipped.WithLabelValues(dspIDStr).Inc()
+			return openrtb.BidResponse{}, err
+		}
+
+		mDSPRequestRetry.WithLabelValues(dspIDStr).Inc()
+	}
+}
+
+// retryBudget reports whether the time left until the auction deadline covers a retry to the DSP.
+func (d *DSPIO) retryBudget(in *In) bool {
+	if in.Deadline.IsZero() {
+		return true
+	}
+
+	needed := d.retryMinBudget
+	if p95, ok := d.latencyTracker(in.DSPID).Quantile(0.95); ok {
+		needed = max(needed, p95)
+	}
+
+	return time.Until(in.Deadline) >= needed
+}
+
+// cloneRequest clones the request under ctx with a fresh copy of its body.
+func cloneRequest(ctx context.Context, req *http.Request) (*http.Request, error) {
+	clone := req.Clone(ctx)
+	if req.GetBody == nil {
+		return clone, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	clone.Body = body
+
+	return clone, nil
+}
+
+// isConnError reports whether err is a connection-level error, such as a refused or reset connection.
+// Context cancellations and deadlines are not.
+func isConnError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLatencyTracker_Quantile(t *testing.T) {
+	var tracker LatencyTracker
+	if _, ok := tracker.Quantile(0.95); ok {
+		t.Fatal("quantile should be unknown without samples")
+	}
+
+	for range 95 {
+		tracker.Observe(10 * time.Millisecond)
+	}
+	for range 5 {
+		tracker.Observe(time.Second)
+	}
+
+	p95, ok := tracker.Quantile(0.95)
+	if !ok || p95 < 10*time.Millisecond || p95 > 11*time.Millisecond {
+		t.Errorf("p95 = %v; want ~10ms", p95)
+	}
+	p99, _ := tracker.Quantile(0.99)
+	if p99 < time.Second || p99 > 1100*time.Millisecond {
+		t.Errorf("p99 = %v; want ~1s", p99)
+	}
+}
+
+func TestIsConnError(t *testing.T) {
+	if !isConnError(syscall.ECONNREFUSED) || !isConnError(io.ErrUnexpectedEOF) {
+		t.Error("refused connections and unexpected EOFs are connection errors")
+	}
+	if isConnError(context.DeadlineExceeded) || isConnError(errors.New("other")) {
+		t.Error("deadlines and other errors are not connection errors")
+	}
+}
+
+func newTestDSPIO() *DSPIO {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	return NewDSPIO(slog.New(slog.DiscardHandler), RoundTripperDoer{transport}, 1, 1, BreakerConfig{}, LimitConfig{Algorithm: LimitStatic}, 0)
+}
+
+func newTestIn(t *testing.T, url string, hedge bool, retries int) In {
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, url, bytes.NewBufferString(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return In{DSPID: 1, BidRequest: req, Hedge: hedge, Retries: retries}
+}
+
+func TestDSPIO_Hedge(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if calls.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		io.WriteString(w, `{"id":"hedged"}`)
+	}))
+	defer server.Close()
+
+	d := newTestDSPIO()
+	for range latencyMinSamples {
+		d.latencyTracker(1).Observe(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	res, err := d.hedge(newTestIn(t, server.URL, true, 0), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != "hedged" {
+		t.Errorf("ID = %q; want hedged", res.ID)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("hedged request took %v", elapsed)
+	}
+}
+
+func TestDSPIO_Retry(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		io.WriteString(w, `{"id":"retried"}`)
+	}))
+	defer server.Close()
+
+	d := newTestDSPIO()
+
+	if _, err := d.attempt(t.Context(), newTestIn(t, server.URL, false, 0), "1"); err == nil {
+		t.Fatal("request without retries should fail")
+	}
+
+	calls.Store(0)
+	in := newTestIn(t, server.URL, false, 1)
+	res, err := d.attempt(in.BidRequest.Context(), in, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != "retried" || calls.Load() != 2 {
+		t.Errorf("ID = %q after %d calls; want retried after 2", res.ID, calls.Load())
+	}
+
+	calls.Store(0)
+	d.retryMinBudget = time.Second
+	in = newTestIn(t, server.URL, false, 1)
+	in.Deadline = time.Now().Add(5 * time.Millisecond)
+	if _, err := d.attempt(in.BidRequest.Context(), in, "1"); err == nil {
+		t.Error("retry should be skipped when the budget does not cover it")
+	}
+}
+
+func TestDSPIO_RetryAfterAuctionClosed(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		// The connection fails after the auction deadline, within the late grace of the DSP context.
+		time.Sleep(30 * time.Millisecond)
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer server.Close()
+
+	deadline := time.Now().Add(10 * time.Millisecond)
+	auction, cancel := context.WithDeadline(t.Context(), deadline)
+	defer cancel()
+
+	in := newTestIn(t, server.URL, false, 1)
+	in.Deadline, in.Closed = deadline, auction.Done()
+
+	if _, err := newTestDSPIO().attempt(in.BidRequest.Context(), in, "1"); !errors.Is(err, errAuctionClosed) {
+		t.Errorf("err = %v; want %v", err, errAuctionClosed)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("calls = %d; want no retry after the auction closed", n)
+	}
+}
@@ -0,0 +1,754 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HTTP/1.1 client
+// HTTP1Client is a minimal HTTP/1.1 client for DSP requests. Requests are written straight into the
+// connection buffer, without the intermediate allocations of net/http, and only the status, the body framing
+// (Content-Length, chunked) and the Content-Type and Content-Encoding headers of responses are parsed.
+// The round trip state, including the http.Response, its header map and body, is pooled and recycled once the
+// body is closed, so a response must not be used after closing its body. In steady state, the client only
+// allocates for the context.AfterFunc watching a cancelable request context, and for chunked bodies.
+// Connections idle for IdleConnTimeout are closed, along with their read loops.
+// Each host has its own pool of persistent connections. With a pipeline above 1, up to that many
+// requests are written on a connection before their responses are read, in order. Pipelined requests
+// share the fate of their connection: a canceled request or a broken response closes the connection
+// and fails the requests behind it.
+// --
+
+// HTTP1Config configures an HTTP1Client.
+type HTTP1Config struct {
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// TLSConfig is used for https:// requests.
+	TLSConfig       *tls.Config
+	MaxConnsPerHost int
+	Pipeline        int
+	IdleConnTimeout time.Duration
+}
+
+// http1BufferSize is the size of the connection buffers. Response lines must fit in it.
+const http1BufferSize = 8 << 10
+
+// http1MaxDrain is the most bytes read from an unread response body to keep its connection.
+const http1MaxDrain = 256 << 10
+
+var (
+	errHTTP1ConnClosed    = errors.New("http1: connection closed")
+	errHTTP1ContentLength = errors.New("http1: request body without content length")
+	errHTTP1Malformed     = errors.New("http1: malformed response")
+)
+
+// HTTP1Client is a minimal HTTP/1.1 client. It is safe for concurrent use.
+type HTTP1Client struct {
+	config HTTP1Config
+	mu     sync.Mutex
+	hosts  map[http1HostKey]*http1Host
+}
+
+// http1HostKey identifies the connection pool of a host.
+type http1HostKey struct {
+	scheme, host string
+}
+
+// NewHTTP1Client creates a new HTTP/1.1 client.
+func NewHTTP1Client(config HTTP1Config) *HTTP1Client {
+	if config.DialContext == nil {
+		config.DialContext = (&net.Dialer{}).DialContext
+	}
+	config.MaxConnsPerHost = max(config.MaxConnsPerHost, 1)
+	config.Pipeline = max(config.Pipeline, 1)
+
+	return &HTTP1Client{config: config, hosts: make(map[http1HostKey]*http1Host)}
+}
+
+// Do sends the request on a pooled connection. The response body must be closed to release the connection,
+// and the response must not be used after that.
+func (c *HTTP1Client) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if req.Body != nil && req.Body != http.NoBody && req.ContentLength <= 0 {
+		req.Body.Close()
+		return nil, errHTTP1ContentLength
+	}
+
+	h := c.host(req)
+
+	select {
+	case h.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	conn, err := h.acquire(ctx)
+	if err != nil {
+		<-h.slots
+		return nil, err
+	}
+
+	res, err := conn.roundTrip(ctx, req, h)
+	if err != nil {
+		h.release(conn)
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// host returns the connection pool of the request host.
+func (c *HTTP1Client) host(req *http.Request) *http1Host {
+	key := http1HostKey{scheme: req.URL.Scheme, host: req.URL.Host}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if h, ok := c.hosts[key]; ok {
+		return h
+	}
+
+	addr, port := req.URL.Host, req.URL.Port()
+	if port == "" {
+		port = "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(req.URL.Hostname(), port)
+	}
+
+	h := &http1Host{
+		client:     c,
+		addr:       addr,
+		serverName: req.URL.Hostname(),
+		tls:        req.URL.Scheme == "https",
+		slots:      make(chan struct{}, c.config.MaxConnsPerHost*c.config.Pipeline),
+		dialed:     make(chan struct{}),
+	}
+	c.hosts[key] = h
+
+	return h
+}
+
+// http1Host is the connection pool of a host.
+// slots bounds the requests in flight to MaxConnsPerHost connections of Pipeline requests each.
+type http1Host struct {
+	client     *HTTP1Client
+	addr       string
+	serverName string
+	tls        bool
+	slots      chan struct{}
+
+	mu      sync.Mutex
+	conns   []*http1Conn
+	dialing int
+	// dialed is closed and replaced each time a dial finishes.
+	dialed chan struct{}
+}
+
+// acquire returns the least busy connection with room for a request, dialing a new one if none has.
+// When the host is at MaxConnsPerHost and its connections are being dialed, it waits for a dial to finish.
+func (h *http1Host) acquire(ctx context.Context) (*http1Conn, error) {
+	config := &h.client.config
+
+	for {
+		now := time.Now()
+
+		h.mu.Lock()
+		var best *http1Conn
+		live := h.conns[:0]
+		for _, conn := range h.conns {
+			idle := conn.inflight == 0 && config.IdleConnTimeout > 0 && now.Sub(conn.lastUsed) > config.IdleConnTimeout
+			if conn.broken() || idle {
+				conn.close(errHTTP1ConnClosed)
+				continue
+			}
+			live = append(live, conn)
+			if conn.inflight < config.Pipeline && (best == nil || conn.inflight < best.inflight) {
+				best = conn
+			}
+		}
+		clear(h.conns[len(live):])
+		h.conns = live
+		full := len(h.conns)+h.dialing >= config.MaxConnsPerHost
+
+		// An idle connection is preferred; a busy one only when no more connections can be opened.
+		if best != nil && (best.inflight == 0 || full) {
+			best.inflight++
+			h.mu.Unlock()
+			return best, nil
+		}
+
+		if !full {
+			h.dialing++
+			h.mu.Unlock()
+
+			conn, err := h.dial(ctx)
+
+			h.mu.Lock()
+			h.dialing--
+			if err == nil {
+				conn.inflight++
+				h.conns = append(h.conns, conn)
+			}
+			close(h.dialed)
+			h.dialed = make(chan struct{})
+			h.mu.Unlock()
+
+			return conn, err
+		}
+
+		dialed := h.dialed
+		h.mu.Unlock()
+
+		select {
+		case <-dialed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// release gives back the request slot taken on the connection.
+// A connection left without requests is reaped after IdleConnTimeout unless it is used again.
+func (h *http1Host) release(conn *http1Conn) {
+	timeout := h.client.config.IdleConnTimeout
+
+	h.mu.Lock()
+	conn.inflight--
+	conn.lastUsed = time.Now()
+	if conn.inflight == 0 && timeout > 0 {
+		if conn.idle == nil {
+			conn.idle = time.AfterFunc(timeout, func() { h.reap(conn) })
+		} else {
+			conn.idle.Reset(timeout)
+		}
+	}
+	h.mu.Unlock()
+
+	<-h.slots
+}
+
+// reap closes the connection and removes it from the pool if it is still idle.
+func (h *http1Host) reap(conn *http1Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if conn.inflight > 0 || time.Since(conn.lastUsed) < h.client.config.IdleConnTimeout {
+		return
+	}
+
+	conn.close(errHTTP1ConnClosed)
+	h.conns = slices.DeleteFunc(h.conns, func(c *http1Conn) bool { return c == conn })
+}
+
+func (h *http1Host) dial(ctx context.Context) (*http1Conn, error) {
+	config := &h.client.config
+
+	nc, err := config.DialContext(ctx, "tcp", h.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.tls {
+		tlsConfig := &tls.Config{}
+		if config.TLSConfig != nil {
+			tlsConfig = config.TLSConfig.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = h.serverName
+		}
+		tlsConfig.NextProtos = []string{"http/1.1"}
+
+		tc := tls.Client(nc, tlsConfig)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tc
+	}
+
+	conn := &http1Conn{
+		conn:     nc,
+		br:       bufio.NewReaderSize(nc, http1BufferSize),
+		bw:       bufio.NewWriterSize(nc, http1BufferSize),
+		pending:  make(chan *http1Call, config.Pipeline),
+		closed:   make(chan struct{}),
+		lastUsed: time.Now(),
+	}
+	go conn.readLoop()
+
+	return conn, nil
+}
+
+// http1Call is the state of a round trip: the request, its response and the response body.
+// done is signaled once the response is read, by a send rather than a close, so calls can be pooled.
+// A call is put back in the pool by the read loop once its body is closed, or by roundTrip when it fails.
+type http1Call struct {
+	req  *http.Request
+	err  error
+	done chan struct{}
+
+	// conn and ctx are read by abort, which closes the connection when the request is canceled.
+	conn   *http1Conn
+	ctx    context.Context
+	cancel func()
+	stop   func() bool
+
+	res     http.Response
+	body    http1Body
+	header  http.Header
+	values  [2]string
+	limited io.LimitedReader
+	drain   io.LimitedReader
+}
+
+// http1Calls pools the calls of the round trips.
+var http1Calls = sync.Pool{New: func() any {
+	call := &http1Call{done: make(chan struct{}, 1), header: make(http.Header, 2)}
+	call.cancel = call.abort
+	call.body.call = call
+	call.body.done = make(chan struct{}, 1)
+	return call
+}}
+
+func putHTTP1Call(call *http1Call) {
+	call.req, call.err, call.conn, call.ctx, call.stop = nil, nil, nil, nil, nil
+	call.res = http.Response{}
+	call.body.r, call.body.err, call.body.host = nil, nil, nil
+	call.body.closed.Store(false)
+	call.limited, call.drain = io.LimitedReader{}, io.LimitedReader{}
+	http1Calls.Put(call)
+}
+
+// watch closes the connection when the request context is canceled, even while the request is written or its
+// body read, which fails the pending calls and unblocks a write stalled on the DSP.
+func (call *http1Call) watch() {
+	if call.ctx.Done() != nil {
+		call.stop = context.AfterFunc(call.ctx, call.cancel)
+	}
+}
+
+// unwatch stops watching the request context. It reports false when the request was canceled,
+// as abort may then still be reading the call.
+func (call *http1Call) unwatch() bool {
+	return call.stop == nil || call.stop()
+}
+
+func (call *http1Call) abort() {
+	call.conn.close(call.ctx.Err())
+}
+
+// headerValue returns the value of a response header as a slice backed by the call, reusing the string of
+// a previous response with the same value.
+func (call *http1Call) headerValue(i int, value []byte) []string {
+	if call.values[i] != string(value) {
+		call.values[i] = string(value)
+	}
+	return call.values[i : i+1 : i+1]
+}
+
+// http1Conn is a persistent connection. Requests are written under mu in the order their calls are queued
+// in pending, and readLoop reads the responses in that same order.
+type http1Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	mu      sync.Mutex
+	bw      *bufio.Writer
+	pending chan *http1Call
+	err     error
+
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// inflight, lastUsed and idle are guarded by the host mutex.
+	inflight int
+	lastUsed time.Time
+	idle     *time.Timer
+}
+
+// roundTrip writes the request and waits for its response.
+// The request slot taken on h is released once the response body is closed.
+func (c *http1Conn) roundTrip(ctx context.Context, req *http.Request, h *http1Host) (*http.Response, error) {
+	call := http1Calls.Get().(*http1Call)
+	call.req, call.conn, call.ctx = req, c, ctx
+	call.watch()
+
+	c.mu.Lock()
+	if err := c.err; err != nil {
+		c.mu.Unlock()
+		if call.unwatch() {
+			putHTTP1Call(call)
+		}
+		return nil, err
+	}
+	c.pending <- call
+	err := c.writeRequest(req)
+	c.mu.Unlock()
+
+	if err != nil {
+		c.close(err)
+	}
+
+	<-call.done
+	if err == nil {
+		err = call.err
+	}
+	if err != nil {
+		// The connection is marked closed before it is released, so it is not handed out again.
+		c.close(err)
+		if call.unwatch() {
+			putHTTP1Call(call)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	call.body.host = h
+
+	return &call.res, nil
+}
+
+// writeRequest writes the request line, the headers and the body into the connection.
+func (c *http1Conn) writeRequest(req *http.Request) error {
+	w := c.bw
+
+	w.WriteString(req.Method)
+	w.WriteByte(' ')
+	writeRequestURI(w, req.URL)
+	w.WriteString(" HTTP/1.1\r\nHost: ")
+	if req.Host != "" {
+		w.WriteString(req.Host)
+	} else {
+		w.WriteString(req.URL.Host)
+	}
+	w.WriteString("\r\n")
+
+	for key, values := range req.Header {
+		for _, value := range values {
+			w.WriteString(key)
+			w.WriteString(": ")
+			w.WriteString(value)
+			w.WriteString("\r\n")
+		}
+	}
+
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	if hasBody || req.Method == http.MethodPost || req.Method == http.MethodPut {
+		w.WriteString("Content-Length: ")
+		w.Write(strconv.AppendInt(w.AvailableBuffer(), max(req.ContentLength, 0), 10))
+		w.WriteString("\r\n")
+	}
+	w.WriteString("\r\n")
+
+	if hasBody {
+		_, err := io.Copy(w, req.Body)
+		req.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+// writeRequestURI writes the request URI of u like u.RequestURI, without concatenating its query.
+func writeRequestURI(w *bufio.Writer, u *url.URL) {
+	if u.Opaque != "" {
+		w.WriteString(u.RequestURI())
+		return
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	w.WriteString(path)
+	if u.ForceQuery || u.RawQuery != "" {
+		w.WriteByte('?')
+		w.WriteString(u.RawQuery)
+	}
+}
+
+// readLoop reads the responses of the queued calls in order, waiting for each body to be consumed
+// before reading the next response.
+func (c *http1Conn) readLoop() {
+	for {
+		var call *http1Call
+		select {
+		case call = <-c.pending:
+		case <-c.closed:
+			c.failPending()
+			return
+		}
+
+		if err := c.readResponse(call); err != nil {
+			call.err = err
+			call.done <- struct{}{}
+			c.close(err)
+			c.failPending()
+			return
+		}
+		call.done <- struct{}{}
+
+		select {
+		case <-call.body.done:
+		case <-c.closed:
+			c.failPending()
+			return
+		}
+
+		// A body that failed may still be read by abort, so its call is left to the garbage collector.
+		closing := call.body.err != nil || call.res.Close
+		if call.body.err == nil {
+			putHTTP1Call(call)
+		}
+		if closing {
+			c.close(errHTTP1ConnClosed)
+			c.failPending()
+			return
+		}
+	}
+}
+
+// failPending fails the calls queued on a closed connection.
+func (c *http1Conn) failPending() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		select {
+		case call := <-c.pending:
+			call.err = c.err
+			call.done <- struct{}{}
+		default:
+			return
+		}
+	}
+}
+
+// readResponse parses the status line and the headers of a response.
+// Informational (1xx) responses are discarded, except 101, which ends the exchange like a final response.
+func (c *http1Conn) readResponse(call *http1Call) error {
+	for {
+		if err := c.readHeader(call); err != nil {
+			return err
+		}
+		if status := call.res.StatusCode; status >= http.StatusOK || status == http.StatusSwitchingProtocols {
+			return nil
+		}
+	}
+}
+
+// readHeader parses one status line and its headers into the call response, and frames the body that follows them.
+func (c *http1Conn) readHeader(call *http1Call) error {
+	req := call.req
+
+	line, err := c.br.ReadSlice('\n')
+	if err != nil {
+		return err
+	}
+	if len(line) < 12 || !bytes.HasPrefix(line, []byte("HTTP/1.")) || line[8] != ' ' {
+		return errHTTP1Malformed
+	}
+	status, ok := parseDigits(line[9:12])
+	if !ok || status < 100 {
+		return errHTTP1Malformed
+	}
+
+	clear(call.header)
+	res := &call.res
+	*res = http.Response{
+		Status:     http1Status(int(status)),
+		StatusCode: int(status),
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     call.header,
+		Request:    req,
+	}
+	if line[7] == '0' {
+		res.Proto, res.ProtoMinor, res.Close = "HTTP/1.0", 0, true
+	}
+
+	chunked := false
+	res.ContentLength = -1
+	for {
+		line, err = c.br.ReadSlice('\n')
+		if err != nil {
+			return err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			break
+		}
+
+		sep := bytes.IndexByte(line, ':')
+		if sep <= 0 {
+			return errHTTP1Malformed
+		}
+		key, value := line[:sep], bytes.TrimSpace(line[sep+1:])
+
+		switch {
+		case bytes.EqualFold(key, []byte("Content-Length")):
+			n, ok := parseDigits(value)
+			if !ok {
+				return errHTTP1Malformed
+			}
+			res.ContentLength = n
+		case bytes.EqualFold(key, []byte("Transfer-Encoding")):
+			chunked = bytes.EqualFold(value, []byte("chunked"))
+		case bytes.EqualFold(key, []byte("Connection")):
+			if bytes.EqualFold(value, []byte("close")) {
+				res.Close = true
+			} else if bytes.EqualFold(value, []byte("keep-alive")) {
+				res.Close = false
+			}
+		case bytes.EqualFold(key, []byte("Content-Type")):
+			res.Header["Content-Type"] = call.headerValue(0, value)
+		case bytes.EqualFold(key, []byte("Content-Encoding")):
+			res.Header["Content-Encoding"] = call.headerValue(1, value)
+		}
+	}
+
+	body := &call.body
+	switch {
+	case status == http.StatusNoContent || status == http.StatusNotModified || status < http.StatusOK || req.Method == http.MethodHead:
+		res.ContentLength = 0
+		body.r = http.NoBody
+		// After 101 the connection speaks another protocol.
+		res.Close = res.Close || status == http.StatusSwitchingProtocols
+	case chunked:
+		res.ContentLength = -1
+		res.TransferEncoding = http1Chunked
+		body.r = httputil.NewChunkedReader(c.br)
+	case res.ContentLength >= 0:
+		call.limited = io.LimitedReader{R: c.br, N: res.ContentLength}
+		body.r = &call.limited
+	default:
+		// Without framing, the body runs until the server closes the connection.
+		res.Close = true
+		body.r = c.br
+	}
+	res.Body = body
+
+	return nil
+}
+
+// http1Chunked is the TransferEncoding of chunked responses.
+var http1Chunked = []string{"chunked"}
+
+// http1Statuses holds the Status of the responses with a known status code, so it is not formatted per response.
+var http1Statuses = func() (statuses [600]string) {
+	for code := http.StatusContinue; code < len(statuses); code++ {
+		if text := http.StatusText(code); text != "" {
+			statuses[code] = strconv.Itoa(code) + " " + text
+		}
+	}
+	return statuses
+}()
+
+// http1Status returns the Status of a response with the given status code.
+func http1Status(code int) string {
+	if code < len(http1Statuses) && http1Statuses[code] != "" {
+		return http1Statuses[code]
+	}
+	return strconv.Itoa(code)
+}
+
+// parseDigits parses a non-negative decimal number without allocating.
+func parseDigits(b []byte) (int64, bool) {
+	if len(b) == 0 || len(b) > 18 {
+		return 0, false
+	}
+
+	var n int64
+	for _, d := range b {
+		if d < '0' || d > '9' {
+			return 0, false
+		}
+		n = n*10 + int64(d-'0')
+	}
+
+	return n, true
+}
+
+// close closes the connection; requests still queued on it fail with err.
+func (c *http1Conn) close(err error) {
+	c.closeOnce.Do(func() {
+		// The connection is closed first, so a write blocked under mu fails and releases it.
+		c.conn.Close()
+
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+
+		close(c.closed)
+	})
+}
+
+// broken reports whether the connection is closed.
+func (c *http1Conn) broken() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// http1Body is a response body. Closing it drains what is left unread, so the next pipelined
+// response can be read, and gives the request slot back to the pool.
+type http1Body struct {
+	r      io.Reader
+	err    error
+	done   chan struct{}
+	closed atomic.Bool
+	call   *http1Call
+	host   *http1Host
+}
+
+func (b *http1Body) Read(p []byte) (int, error) {
+	return b.r.Read(p)
+}
+
+// Close drains the body; a body larger than http1MaxDrain, or that cannot be read, closes the connection.
+func (b *http1Body) Close() error {
+	if !b.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	call := b.call
+	call.drain = io.LimitedReader{R: b.r, N: http1MaxDrain}
+	n, err := io.Copy(io.Discard, &call.drain)
+	canceled := !call.unwatch()
+	switch {
+	case err != nil:
+		b.err = err
+	case n == http1MaxDrain, canceled:
+		// A request canceled while its body was read has its connection closed.
+		b.err = errHTTP1ConnClosed
+	}
+
+	// The read loop recycles the call once done is signaled.
+	host, conn := b.host, call.conn
+	b.done <- struct{}{}
+	host.release(conn)
+
+	return nil
+}
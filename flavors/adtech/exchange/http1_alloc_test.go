@@ -0,0 +1,77 @@
+//go:build !race
+
+// The race detector makes sync.Pool drop items, so allocations are only counted without it.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// rewindBody is a request body that rewinds when closed, so its request can be sent again.
+type rewindBody struct {
+	*bytes.Reader
+}
+
+func (b rewindBody) Close() error {
+	b.Seek(0, io.SeekStart)
+	return nil
+}
+
+func TestHTTP1Client_Allocs(t *testing.T) {
+	// The DSP answers every request on the other end of an in-memory connection, without allocating.
+	request := []byte("POST /bid?id=1 HTTP/1.1\r\nHost: dsp\r\nContent-Length: 10\r\n\r\n{\"id\":\"1\"}")
+	response := []byte("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 2\r\n\r\n{}")
+	clientConn, dspConn := net.Pipe()
+	defer dspConn.Close()
+	go func() {
+		buf := make([]byte, len(request))
+		for {
+			if _, err := io.ReadFull(dspConn, buf); err != nil {
+				return
+			}
+			if !bytes.Equal(buf, request) {
+				t.Errorf("request = %q; want %q", buf, request)
+				return
+			}
+			if _, err := dspConn.Write(response); err != nil {
+				return
+			}
+		}
+	}()
+
+	client := NewHTTP1Client(HTTP1Config{DialContext: func(context.Context, string, string) (net.Conn, error) {
+		return clientConn, nil
+	}})
+	req, _ := http.NewRequest(http.MethodPost, "http://dsp/bid?id=1", rewindBody{bytes.NewReader([]byte(`{"id":"1"}`))})
+	req.ContentLength = 10
+
+	do := func() {
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/json" {
+			t.Fatalf("response = %s %v", res.Status, res.Header)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+
+	if allocs := testing.AllocsPerRun(100, do); allocs != 0 {
+		t.Errorf("allocs per request = %v; want 0", allocs)
+	}
+
+	// Only watching a cancelable context allocates.
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	req = req.WithContext(ctx)
+	if allocs := testing.AllocsPerRun(100, do); allocs > 2 {
+		t.Errorf("allocs per request with a cancelable context = %v; want at most 2", allocs)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"perftest/libs/openrtb"
+)
+
+// Invalid requests
+// Malformed ad requests are answered with a 400 carrying a structured error, never a 5xx,
+// so bad traffic is counted apart from exchange failures.
+// --
+
+// Exchange specific validation error codes, on top of the openrtb.Validation* ones.
+const (
+	InvalidEncoding      = "invalid_encoding"
+	InvalidJSON          = "invalid_json"
+	InvalidInventoryID   = "invalid_inventory_id"
+	InvalidFloorCurrency = "invalid_floor_currency"
+)
+
+// writeInvalidRequest answers the request with a 400 describing err.
+// A *openrtb.ValidationError is written as is; any other error is written with the given code.
+func writeInvalidRequest(w http.ResponseWriter, code string, err error) {
+	var verr *openrtb.ValidationError
+	if !errors.As(err, &verr) {
+		verr = &openrtb.ValidationError{Code: code, Message: err.Error()}
+	}
+
+	mAdRequestInvalid.WithLabelValues(verr.Code).Inc()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(verr)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"perftest/libs/intern"
+	"perftest/libs/openrtb"
+)
+
+// Inventory
+// Ad requests come either from an app or from a site (web). Both are resolved against their cache
+// into a common Inventory, which carries the publisher used for metrics, floors, block lists and deals.
+// --
+
+// Inventory types.
+const (
+	InventoryApp  = "app"
+	InventorySite = "site"
+)
+
+type Site struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Domain    string     `json:"domain"`
+	Publisher *Publisher `json:"publisher"`
+}
+
+// Sites holds a map of sites for quick lookup.
+type Sites struct {
+	Sites map[int]*Site
+}
+
+// Inventory is the resolved app or site of an ad request. Exactly one of App or Site is set.
+type Inventory struct {
+	Type      string
+	ID        int
+	App       *App
+	Site      *Site
+	Publisher *Publisher
+}
+
+// PublisherID returns the ID of the inventory publisher, or 0 if it has none.
+func (inv *Inventory) PublisherID() int {
+	if inv == nil || inv.Publisher == nil {
+		return 0
+	}
+
+	return inv.Publisher.ID
+}
+
+// resolveInventory resolves the app or site of a validated request against the cache.
+// It returns a nil Inventory for unknown inventory, and an error when the inventory ID is not numeric.
+func resolveInventory(state *State, request *openrtb.BidRequest) (*Inventory, error) {
+	if request.App != nil {
+		id, err := strconv.Atoi(request.App.ID)
+		if err != nil {
+			return nil, err
+		}
+		app := state.Apps.Load().Apps[id]
+		if app == nil {
+			return nil, nil
+		}
+
+		return &Inventory{Type: InventoryApp, ID: app.ID, App: app, Publisher: app.Publisher}, nil
+	}
+
+	id, err := strconv.Atoi(request.Site.ID)
+	if err != nil {
+		return nil, err
+	}
+	sites := state.Sites.Load()
+	if sites == nil {
+		return nil, nil
+	}
+	site := sites.Sites[id]
+	if site == nil {
+		return nil, nil
+	}
+
+	return &Inventory{Type: InventorySite, ID: site.ID, Site: site, Publisher: site.Publisher}, nil
+}
+
+// CacheLoadSites loads the sites from the given path.
+// It creates new in-memory objects instead of reusing the unmarshalled structs.
+func CacheLoadSites(path string, useIntern bool) CacheLoadFunc {
+	return func(state *State, logger *slog.Logger) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		var decoded []Site
+		if err = json.NewDecoder(f).Decode(&decoded); err != nil {
+			return err
+		}
+
+		siteMap := make(map[int]*Site, len(decoded))
+		for i := range decoded {
+			src := &decoded[i]
+			site := &Site{
+				ID:     src.ID,
+				Name:   src.Name,
+				Domain: src.Domain,
+			}
+			if useIntern {
+				site.Name = intern.InternString(site.Name)
+				site.Domain = intern.InternString(site.Domain)
+			}
+			if src.Publisher != nil {
+				site.Publisher = &Publisher{
+					ID:   src.Publisher.ID,
+					Name: src.Publisher.Name,
+				}
+				if useIntern {
+					site.Publisher.Name = intern.InternString(site.Publisher.Name)
+				}
+			}
+			siteMap[site.ID] = site
+		}
+
+		state.Sites.Store(&Sites{Sites: siteMap})
+
+		logger.Info("cache: loaded sites", slog.Int("count", len(decoded)))
+
+		return nil
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"perftest/libs/openrtb"
+)
+
+func newTestAppInventory(appID, pubID int) *Inventory {
+	app := &App{ID: appID, Publisher: &Publisher{ID: pubID}}
+	return &Inventory{Type: InventoryApp, ID: appID, App: app, Publisher: app.Publisher}
+}
+
+func newTestSiteInventory(siteID, pubID int) *Inventory {
+	site := &Site{ID: siteID, Publisher: &Publisher{ID: pubID}}
+	return &Inventory{Type: InventorySite, ID: siteID, Site: site, Publisher: site.Publisher}
+}
+
+func TestResolveInventory(t *testing.T) {
+	state := &State{}
+	state.Apps.Store(&Apps{Apps: map[int]*App{1: {ID: 1, Publisher: &Publisher{ID: 10}}}})
+
+	inv, err := resolveInventory(state, &openrtb.BidRequest{Site: &openrtb.Site{ID: "2"}})
+	if err != nil || inv != nil {
+		t.Fatalf("site without sites cache = %+v, %v; want nil, nil", inv, err)
+	}
+
+	state.Sites.Store(&Sites{Sites: map[int]*Site{2: {ID: 2, Publisher: &Publisher{ID: 20}}}})
+
+	tests := []struct {
+		name    string
+		request openrtb.BidRequest
+		typ     string
+		pubID   int
+		wantErr bool
+	}{
+		{"app", openrtb.BidRequest{App: &openrtb.App{ID: "1"}}, InventoryApp, 10, false},
+		{"site", openrtb.BidRequest{Site: &openrtb.Site{ID: "2"}}, InventorySite, 20, false},
+		{"unknown app", openrtb.BidRequest{App: &openrtb.App{ID: "3"}}, "", 0, false},
+		{"unknown site", openrtb.BidRequest{Site: &openrtb.Site{ID: "3"}}, "", 0, false},
+		{"non numeric id", openrtb.BidRequest{Site: &openrtb.Site{ID: "x"}}, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv, err := resolveInventory(state, &tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; wantErr %v", err, tt.wantErr)
+			}
+			if tt.typ == "" {
+				if inv != nil {
+					t.Errorf("inventory = %+v; want nil", inv)
+				}
+				return
+			}
+			if inv == nil || inv.Type != tt.typ || inv.PublisherID() != tt.pubID {
+				t.Errorf("inventory = %+v; want type %s publisher %d", inv, tt.typ, tt.pubID)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Late responses
+// DSP requests outlive their auction by the late grace, so DSPs answering just after the deadline
+// are still seen. Their responses are not decoded: the time past the deadline is recorded per DSP
+// and the body is drained and closed. Requests still queued when their auction closes are not sent.
+// --
+
+// errAuctionClosed is returned for DSP requests whose auction closed before they were sent or answered.
+var errAuctionClosed = errors.New("dspio: auction closed")
+
+// closed reports whether the auction of the request is closed.
+func (in *In) closed() bool {
+	select {
+	case <-in.Closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// discardLateResponse releases the response of a closed auction, recording how late it was.
+func discardLateResponse(in *In, res *http.Response, dspIDStr string) {
+	closeResponse(res)
+
+	if in.Deadline.IsZero() {
+		return
+	}
+	if late := time.Since(in.Deadline); late > 0 {
+		hDSPResponseLate.WithLabelValues(dspIDStr).Observe(late.Seconds())
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestDSPIO_LateResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		time.Sleep(50 * time.Millisecond)
+		io.WriteString(w, `{"id":"late","seatbid":[{"bid":[{"id":"b1","impid":"1","price":1}]}]}`)
+	}))
+	defer server.Close()
+
+	deadline := time.Now().Add(10 * time.Millisecond)
+	auction, cancel := context.WithDeadline(t.Context(), deadline)
+	defer cancel()
+
+	responses := make(chan Out, 1)
+	in := newTestIn(t, server.URL, false, 0)
+	in.DSPID = 9001
+	in.Deadline, in.Closed, in.Responder = deadline, auction.Done(), responses
+
+	var before, after dto.Metric
+	late := hDSPResponseLate.WithLabelValues("9001").(prometheus.Histogram)
+	late.Write(&before)
+
+	newTestDSPIO().Execute(in)
+
+	if out := <-responses; !errors.Is(out.Err, errAuctionClosed) || hasBids(&out.BidResponse) {
+		t.Errorf("out = %+v; want the late response discarded", out)
+	}
+
+	late.Write(&after)
+	n := after.GetHistogram().GetSampleCount() - before.GetHistogram().GetSampleCount()
+	sum := after.GetHistogram().GetSampleSum() - before.GetHistogram().GetSampleSum()
+	if n != 1 || sum < 0.03 {
+		t.Errorf("late responses = %d, overshoot = %.3fs; want 1, ~40ms", n, sum)
+	}
+}
+
+func TestDSPIO_ClosedAuctionNotSent(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer server.Close()
+
+	closed := make(chan struct{})
+	close(closed)
+
+	responses := make(chan Out, 1)
+	in := newTestIn(t, server.URL, false, 0)
+	in.Closed, in.Responder = closed, responses
+
+	newTestDSPIO().Execute(in)
+
+	if out := <-responses; !errors.Is(out.Err, errAuctionClosed) {
+		t.Errorf("err = %v; want %v", out.Err, errAuctionClosed)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("calls = %d; want no request for a closed auction", n)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Adaptive concurrency
+// Instead of the static bulkhead of each DSP, the DSP IO can limit the outstanding requests of a DSP
+// with an adaptive limit, updated from the latency of every request and whether it failed.
+// Requests over the limit are answered with an error without being queued.
+// The DSP workers remain the hard ceiling, so they should be at least the maximum limit.
+// --
+
+// Concurrency limit algorithms.
+const (
+	LimitStatic   = "static"
+	LimitAIMD     = "aimd"
+	LimitVegas    = "vegas"
+	LimitGradient = "gradient"
+)
+
+// errLimitExceeded is returned for requests over the concurrency limit of their DSP.
+var errLimitExceeded = errors.New("dspio: concurrency limit exceeded")
+
+// LimitConfig configures the DSP concurrency limiters.
+type LimitConfig struct {
+	// Algorithm is one of the Limit* algorithms. LimitStatic disables the limiters.
+	Algorithm string
+	Initial   int
+	Min       int
+	Max       int
+}
+
+// LimitAlgorithm computes the next concurrency limit from a request sample.
+// dropped reports whether the request failed or timed out.
+type LimitAlgorithm interface {
+	Update(limit float64, rtt time.Duration, inFlight int, dropped bool) float64
+}
+
+// isStaticLimit reports whether the algorithm disables the limiters.
+func isStaticLimit(algorithm string) bool {
+	return algorithm == "" || algorithm == LimitStatic
+}
+
+// NewLimitAlgorithm returns the algorithm with the given name.
+func NewLimitAlgorithm(name string) (LimitAlgorithm, error) {
+	switch name {
+	case LimitAIMD:
+		return &AIMDLimit{BackoffRatio: 0.9}, nil
+	case LimitVegas:
+		return &VegasLimit{}, nil
+	case LimitGradient:
+		return &GradientLimit{Tolerance: 1.5, Smoothing: 0.2, LongWindow: 600}, nil
+	default:
+		return nil, fmt.Errorf("dspio: unknown concurrency limit algorithm %q", name)
+	}
+}
+
+// AIMDLimit grows the limit by one while the DSP is busy and cuts it by BackoffRatio on failures.
+type AIMDLimit struct {
+	BackoffRatio float64
+}
+
+func (a *AIMDLimit) Update(limit float64, rtt time.Duration, inFlight int, dropped bool) float64 {
+	if dropped {
+		return limit * a.BackoffRatio
+	}
+	if float64(inFlight)*2 >= limit {
+		return limit + 1
+	}
+
+	return limit
+}
+
+// VegasLimit estimates the requests queued at the DSP from the ratio between the lowest latency seen
+// and the current one, growing the limit while the queue is short and shrinking it when it is long.
+type VegasLimit struct {
+	mu        sync.Mutex
+	rttNoLoad time.Duration
+}
+
+func (v *VegasLimit) Update(limit float64, rtt time.Duration, inFlight int, dropped bool) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if rtt <= 0 {
+		return limit
+	}
+	if v.rttNoLoad == 0 || rtt < v.rttNoLoad {
+		v.rttNoLoad = rtt
+		return limit
+	}
+
+	step := max(math.Log10(limit), 1)
+	if dropped {
+		return limit - step
+	}
+
+	queue := limit * (1 - float64(v.rttNoLoad)/float64(rtt))
+	switch {
+	case queue < 3*step:
+		return limit + step
+	case queue > 6*step:
+		return limit - step
+	default:
+		return limit
+	}
+}
+
+// GradientLimit compares a short term latency to a long term average: the limit shrinks as latency
+// rises beyond Tolerance times the average, and grows by a small queue allowance otherwise.
+type GradientLimit struct {
+	Tolerance  float64
+	Smoothing  float64
+	LongWindow int
+
+	mu      sync.Mutex
+	longRTT float64
+	samples int
+}
+
+func (g *GradientLimit) Update(limit float64, rtt time.Duration, inFlight int, dropped bool) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if rtt <= 0 {
+		return limit
+	}
+
+	short := float64(rtt)
+	if g.samples < g.LongWindow {
+		g.samples++
+	}
+	g.longRTT += (short - g.longRTT) / float64(g.samples)
+
+	// Without load the DSP is not the bottleneck; keep the limit.
+	if float64(inFlight) < limit/2 && !dropped {
+		return limit
+	}
+
+	gradient := max(0.5, min(1, g.Tolerance*g.longRTT/short))
+	next := limit*gradient + math.Sqrt(limit)
+
+	return limit*(1-g.Smoothing) + next*g.Smoothing
+}
+
+// ConcurrencyLimiter limits the outstanding requests of a DSP. It is safe for concurrent use.
+type ConcurrencyLimiter struct {
+	mu        sync.Mutex
+	algorithm LimitAlgorithm
+	limit     float64
+	min       float64
+	max       float64
+	inFlight  int
+	gauge     prometheus.Gauge
+}
+
+// NewConcurrencyLimiter creates a limiter starting at the initial limit of the config.
+func NewConcurrencyLimiter(algorithm LimitAlgorithm, config LimitConfig, gauge prometheus.Gauge) *ConcurrencyLimiter {
+	l := &ConcurrencyLimiter{
+		algorithm: algorithm,
+		limit:     float64(config.Initial),
+		min:       float64(max(config.Min, 1)),
+		max:       float64(max(config.Max, config.Min, 1)),
+		gauge:     gauge,
+	}
+	l.limit = max(l.min, min(l.limit, l.max))
+	gauge.Set(math.Floor(l.limit))
+
+	return l
+}
+
+// TryAcquire reserves a slot for a request, reporting false when the DSP is at its limit.
+// Every acquired slot must be given back with Release or Cancel.
+func (l *ConcurrencyLimiter) TryAcquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if float64(l.inFlight) >= math.Floor(l.limit) {
+		return false
+	}
+
+	l.inFlight++
+	return true
+}
+
+// Release gives back the slot of a completed request and updates the limit with its sample.
+func (l *ConcurrencyLimiter) Release(rtt time.Duration, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = max(l.min, min(l.algorithm.Update(l.limit, rtt, l.inFlight, dropped), l.max))
+	l.inFlight = max(l.inFlight-1, 0)
+	l.gauge.Set(math.Floor(l.limit))
+}
+
+// Cancel gives back the slot of a request that was never sent, without updating the limit.
+func (l *ConcurrencyLimiter) Cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight = max(l.inFlight-1, 0)
+}
+
+// Limit returns the current limit.
+func (l *ConcurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.limit)
+}
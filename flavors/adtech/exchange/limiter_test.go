@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	algorithm, _ := NewLimitAlgorithm(LimitAIMD)
+	l := NewConcurrencyLimiter(algorithm, LimitConfig{Initial: 2, Min: 1, Max: 3}, prometheus.NewGauge(prometheus.GaugeOpts{Name: "test"}))
+
+	if !l.TryAcquire() || !l.TryAcquire() || l.TryAcquire() {
+		t.Fatal("limiter should allow exactly 2 requests")
+	}
+	l.Cancel()
+	if !l.TryAcquire() {
+		t.Fatal("cancelled slot should be given back")
+	}
+
+	l.Release(10*time.Millisecond, false)
+	l.Release(10*time.Millisecond, false)
+	if got := l.Limit(); got != 3 {
+		t.Errorf("limit = %d; want 3, capped at the maximum", got)
+	}
+
+	for range 20 {
+		l.TryAcquire()
+		l.Release(time.Second, true)
+	}
+	if got := l.Limit(); got != 1 {
+		t.Errorf("limit = %d; want 1, floored at the minimum", got)
+	}
+}
+
+func TestLimitAlgorithms(t *testing.T) {
+	if _, err := NewLimitAlgorithm("unknown"); err == nil {
+		t.Error("unknown algorithm should fail")
+	}
+	if !isStaticLimit("") || !isStaticLimit(LimitStatic) || isStaticLimit("agimd") {
+		t.Error("only an empty or static algorithm should disable the limiters")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("NewDSPIO should panic on a misspelled algorithm")
+			}
+		}()
+		NewDSPIO(nil, nil, 1, 1, BreakerConfig{}, LimitConfig{Algorithm: "agimd"}, 0)
+	}()
+
+	aimd := &AIMDLimit{BackoffRatio: 0.5}
+	if got := aimd.Update(10, 0, 5, false); got != 11 {
+		t.Errorf("aimd busy = %v; want 11", got)
+	}
+	if got := aimd.Update(10, 0, 1, false); got != 10 {
+		t.Errorf("aimd idle = %v; want 10", got)
+	}
+	if got := aimd.Update(10, 0, 5, true); got != 5 {
+		t.Errorf("aimd dropped = %v; want 5", got)
+	}
+
+	vegas := &VegasLimit{}
+	vegas.Update(20, 10*time.Millisecond, 10, false)
+	if got := vegas.Update(20, 11*time.Millisecond, 10, false); got <= 20 {
+		t.Errorf("vegas short queue = %v; want > 20", got)
+	}
+	if got := vegas.Update(20, 100*time.Millisecond, 10, false); got >= 20 {
+		t.Errorf("vegas long queue = %v; want < 20", got)
+	}
+
+	gradient := &GradientLimit{Tolerance: 1.5, Smoothing: 1, LongWindow: 100}
+	for range 50 {
+		gradient.Update(20, 10*time.Millisecond, 15, false)
+	}
+	if got := gradient.Update(20, 200*time.Millisecond, 15, false); got >= 20 {
+		t.Errorf("gradient latency spike = %v; want < 20", got)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"perftest/libs/openrtb"
+)
+
+// No-bid
+// Requests without a winner are answered with a 204 and no body, or, when configured,
+// with a 200 carrying BidResponse.NBR. Internal outcomes are mapped to exchange specific NBR codes.
+// --
+
+// Exchange specific no-bid reasons.
+const (
+	NBRUnknownInventory = 500
+	NBRTimeout          = 501
+	NBRBelowFloor       = 502
+	NBRBlocked          = 503
+	NBRNoBids           = 504
+	NBRNoEligibleDSP    = 505
+	NBRInvalidBids      = 506
+)
+
+// nbrLabel returns the metric label of a no-bid reason.
+func nbrLabel(nbr int) string {
+	switch nbr {
+	case openrtb.NBRTechnicalError:
+		return "technical_error"
+	case openrtb.NBRInvalidRequest:
+		return "invalid_request"
+	case openrtb.NBRBlockedPublisher:
+		return "blocked_publisher"
+	case NBRUnknownInventory:
+		return "unknown_inventory"
+	case NBRTimeout:
+		return "timeout"
+	case NBRBelowFloor:
+		return "below_floor"
+	case NBRBlocked:
+		return "blocked"
+	case NBRNoBids:
+		return "no_bids"
+	case NBRNoEligibleDSP:
+		return "no_eligible_dsp"
+	case NBRInvalidBids:
+		return "invalid_bids"
+	default:
+		return "unknown"
+	}
+}
+
+// NoBidReason returns why the auction has no winner, based on the most frequent rejection of its bids.
+func (a *Auction) NoBidReason() int {
+	if a.bids == 0 {
+		return NBRNoBids
+	}
+
+	counts := make(map[int]int, 3)
+	for reason, n := range a.rejections {
+		switch reason {
+		case RejectReasonBelowFloor:
+			counts[NBRBelowFloor] += n
+		case RejectReasonBlockedAdomain, RejectReasonBlockedCategory, RejectReasonBlockedAttr,
+			RejectReasonSeatNotAllowed, RejectReasonPrivateAuction:
+			counts[NBRBlocked] += n
+		default:
+			counts[NBRInvalidBids] += n
+		}
+	}
+
+	nbr, most := NBRNoBids, 0
+	for _, code := range []int{NBRBelowFloor, NBRBlocked, NBRInvalidBids} {
+		if counts[code] > most {
+			nbr, most = code, counts[code]
+		}
+	}
+
+	return nbr
+}
+
+// writeNoBid answers the request with a no-bid.
+// withNBR selects a 200 with BidResponse.NBR over the default 204 without body.
+func writeNoBid(w http.ResponseWriter, id string, nbr int, withNBR bool) {
+	mAdRequestNoBid.WithLabelValues(nbrLabel(nbr)).Inc()
+
+	if !withNBR {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(openrtb.BidResponse{ID: id, NBR: nbr})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"perftest/libs/openrtb"
+)
+
+func TestAuction_NoBidReason(t *testing.T) {
+	newAuction := func() *Auction {
+		req := &openrtb.BidRequest{ID: "r1", Imp: []openrtb.Imp{{ID: "1", BidFloor: 2}}, BAdv: []string{"blocked.com"}}
+		return NewAuction(req, AuctionConfig{Currency: "USD", SecondPriceIncrement: 0.01}, nil, nil)
+	}
+
+	a := newAuction()
+	if got := a.NoBidReason(); got != NBRNoBids {
+		t.Errorf("no bids: NoBidReason() = %d; want %d", got, NBRNoBids)
+	}
+
+	a = newAuction()
+	a.Add(newTestOut(1, "", openrtb.Bid{ID: "b1", ImpID: "1", Price: 1}))
+	a.Add(newTestOut(2, "", openrtb.Bid{ID: "b2", ImpID: "1", Price: 1.5}))
+	a.Add(newTestOut(3, "", openrtb.Bid{ID: "b3", ImpID: "1", Price: 5, Adomain: []string{"blocked.com"}}))
+	if got := a.NoBidReason(); got != NBRBelowFloor {
+		t.Errorf("below floor: NoBidReason() = %d; want %d", got, NBRBelowFloor)
+	}
+
+	a = newAuction()
+	a.Add(newTestOut(1, "", openrtb.Bid{ID: "b1", ImpID: "9", Price: 5}))
+	if got := a.NoBidReason(); got != NBRInvalidBids {
+		t.Errorf("invalid bids: NoBidReason() = %d; want %d", got, NBRInvalidBids)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Notice IO
+// The notice IO fires the win (nurl) and billing (burl) notices of the auction winners.
+// Notices are sent in the background by their own worker pool, so they never delay the ad response,
+// and are retried a bounded number of times.
+// The billing notice is simulated: OpenRTB fires burl once the ad is rendered, but the exchange has no
+// render or impression event, so it assumes every winning ad renders and fires burl right after nurl.
+// Billing metrics therefore count auction wins, not billable impressions.
+// --
+
+// Notice kinds.
+const (
+	NoticeKindWin     = "win"
+	NoticeKindBilling = "billing"
+)
+
+// Notice represents a notice to be fired.
+type Notice struct {
+	Kind  string
+	DSPID int
+	URL   string
+}
+
+// NoticeIO represents the notice IO handler.
+type NoticeIO struct {
+	logger    *slog.Logger
+	transport http.RoundTripper
+	pool      int
+	retries   int
+	backoff   time.Duration
+	timeout   time.Duration
+	input     chan Notice
+	done      chan struct{}
+}
+
+// NewNoticeIO creates a new notice IO handler.
+// queue is the number of notices waiting for a worker before new ones are dropped.
+func NewNoticeIO(logger *slog.Logger, transport http.RoundTripper, pool, queue, retries int, backoff, timeout time.Duration) *NoticeIO {
+	return &NoticeIO{
+		logger:    logger,
+		transport: transport,
+		pool:      pool,
+		retries:   retries,
+		backoff:   backoff,
+		timeout:   timeout,
+		input:     make(chan Notice, queue),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start starts the notice IO background workers.
+func (n *NoticeIO) Start(ctx context.Context) {
+	for range n.pool {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-n.done:
+					return
+				case notice := <-n.input:
+					n.Execute(ctx, notice)
+				}
+			}
+		}()
+	}
+}
+
+// Stop stops the notice IO background workers.
+func (n *NoticeIO) Stop() {
+	close(n.done)
+}
+
+// Notify enqueues the win and billing notices of the winner, with the auction macros substituted.
+// The billing notice is fired at auction time, as if the ad rendered at once; see the Notice IO section.
+func (n *NoticeIO) Notify(auctionID string, winner *Winner) {
+	if winner.Bid.NURL != "" {
+		n.Enqueue(Notice{Kind: NoticeKindWin, DSPID: winner.DSPID, URL: expandNoticeURL(winner.Bid.NURL, auctionID, winner)})
+	}
+	if winner.Bid.BURL != "" {
+		n.Enqueue(Notice{Kind: NoticeKindBilling, DSPID: winner.DSPID, URL: expandNoticeURL(winner.Bid.BURL, auctionID, winner)})
+	}
+}
+
+// Enqueue enqueues a notice to be fired by the background workers.
+// The notice is dropped when the queue is full.
+func (n *NoticeIO) Enqueue(notice Notice) {
+	dspIDStr := strconv.Itoa(notice.DSPID)
+
+	mNoticeTotal.WithLabelValues(dspIDStr, notice.Kind).Inc()
+
+	select {
+	case n.input <- notice:
+	default:
+		mNoticeDropped.WithLabelValues(dspIDStr, notice.Kind).Inc()
+	}
+}
+
+// Execute fires the notice, retrying on transport errors and 5xx responses with an exponential backoff.
+func (n *NoticeIO) Execute(ctx context.Context, notice Notice) {
+	dspIDStr := strconv.Itoa(notice.DSPID)
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		err := n.fire(ctx, notice.URL)
+		hNoticeDuration.WithLabelValues(notice.Kind).Observe(time.Since(start).Seconds())
+
+		if err == nil {
+			return
+		}
+
+		if attempt >= n.retries || ctx.Err() != nil || errors.Is(err, errNoticeRejected) {
+			n.logger.Info("noticeio: notice error", slog.Int("dsp_id", notice.DSPID), slog.String("kind", notice.Kind), slog.Any("error", err))
+			mNoticeError.WithLabelValues(dspIDStr, notice.Kind).Inc()
+			return
+		}
+
+		mNoticeRetry.WithLabelValues(dspIDStr, notice.Kind).Inc()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.done:
+			return
+		case <-time.After(n.backoff << attempt):
+		}
+	}
+}
+
+func (n *NoticeIO) fire(ctx context.Context, noticeURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, noticeURL, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := n.transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("noticeio: unexpected status %d", res.StatusCode)
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%w: status %d", errNoticeRejected, res.StatusCode)
+	}
+
+	return nil
+}
+
+// errNoticeRejected is returned for notices answered with a 4xx status. These are not retried.
+var errNoticeRejected = errors.New("noticeio: notice rejected")
+
+// expandNoticeURL substitutes the auction macros in a notice URL.
+func expandNoticeURL(noticeURL, auctionID string, winner *Winner) string {
+	values := winner.MacroValues(auctionID)
+	return urlMacros.Expand(noticeURL, &values)
+}
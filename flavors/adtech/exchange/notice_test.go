@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"perftest/libs/openrtb"
+)
+
+func TestExpandNoticeURL(t *testing.T) {
+	winner := &Winner{
+		DSPID:    1,
+		Seat:     "seat 1",
+		Bid:      openrtb.Bid{ID: "b1", ImpID: "2", Price: 1.25},
+		Currency: "USD",
+	}
+	got := expandNoticeURL("https://dsp/win?id=${AUCTION_ID}&imp=${AUCTION_IMP_ID}&seat=${AUCTION_SEAT_ID}&p=${AUCTION_PRICE}&c=${AUCTION_CURRENCY}", "a&1", winner)
+	want := "https://dsp/win?id=a%261&imp=2&seat=seat+1&p=1.25&c=USD"
+	if got != want {
+		t.Errorf("expandNoticeURL() = %q; want %q", got, want)
+	}
+}
+
+func TestNoticeIO_ExecuteRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	n := NewNoticeIO(logger, http.DefaultTransport, 1, 1, 2, time.Millisecond, time.Second)
+	n.Execute(context.Background(), Notice{Kind: NoticeKindWin, DSPID: 1, URL: srv.URL})
+
+	if got := calls.Load(); got != 3 {
+		t.Errorf("calls = %d; want 3", got)
+	}
+}
+
+func TestNoticeIO_ExecuteDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	n := NewNoticeIO(logger, http.DefaultTransport, 1, 1, 2, time.Millisecond, time.Second)
+	n.Execute(context.Background(), Notice{Kind: NoticeKindWin, DSPID: 1, URL: srv.URL})
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("calls = %d; want 1", got)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"perftest/libs/openrtb"
+)
+
+// DSP responses
+// A 200 is decoded as a bid response; a 204 or a 200 with an empty body is a no-bid, as in OpenRTB.
+// Any other status is an HTTP error and its body is not decoded. gzip bodies are decompressed.
+// Bodies are always drained, up to maxResponseDrain, and closed, so the connection can be reused.
+// Each response is counted per DSP by its outcome; responses of closed auctions are late, see Late responses.
+// --
+
+// Outcomes of a DSP request.
+const (
+	OutcomeBid         = "bid"
+	OutcomeNoBid       = "nobid"
+	OutcomeHTTPError   = "http_error"
+	OutcomeDecodeError = "decode_error"
+	OutcomeTimeout     = "timeout"
+	OutcomeLate        = "late"
+	OutcomeError       = "error"
+)
+
+// maxResponseDrain is the most bytes read from a response body before closing it.
+// Larger leftovers close the connection instead of draining it.
+const maxResponseDrain = 64 << 10
+
+var (
+	// errResponseStatus is returned for responses other than 200 and 204.
+	errResponseStatus = errors.New("dspio: unexpected response status")
+	// errResponseDecode is returned for responses whose body cannot be decoded.
+	errResponseDecode = errors.New("dspio: invalid response body")
+)
+
+// readResponse reads the bid response of a DSP and releases the response body.
+func readResponse(res *http.Response) (bidResponse openrtb.BidResponse, err error) {
+	defer closeResponse(res)
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return openrtb.BidResponse{}, nil
+	default:
+		return openrtb.BidResponse{}, fmt.Errorf("%w: %d", errResponseStatus, res.StatusCode)
+	}
+
+	var body io.Reader = res.Body
+	if strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(res.Body)
+		if errors.Is(err, io.EOF) {
+			return openrtb.BidResponse{}, nil
+		}
+		if err != nil {
+			return openrtb.BidResponse{}, fmt.Errorf("%w: %w", errResponseDecode, err)
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	if err = json.NewDecoder(body).Decode(&bidResponse); err != nil {
+		if errors.Is(err, io.EOF) {
+			return openrtb.BidResponse{}, nil
+		}
+		return openrtb.BidResponse{}, fmt.Errorf("%w: %w", errResponseDecode, err)
+	}
+
+	return bidResponse, nil
+}
+
+// closeResponse drains and closes the response body.
+func closeResponse(res *http.Response) {
+	io.CopyN(io.Discard, res.Body, maxResponseDrain)
+	res.Body.Close()
+}
+
+// responseOutcome classifies the result of a DSP request.
+func responseOutcome(bidResponse *openrtb.BidResponse, err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil && hasBids(bidResponse):
+		return OutcomeBid
+	case err == nil:
+		return OutcomeNoBid
+	case errors.Is(err, errAuctionClosed):
+		return OutcomeLate
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return OutcomeTimeout
+	case errors.Is(err, errResponseStatus):
+		return OutcomeHTTPError
+	case errors.Is(err, errResponseDecode):
+		return OutcomeDecodeError
+	default:
+		return OutcomeError
+	}
+}
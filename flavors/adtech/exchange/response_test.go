@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReadResponse(t *testing.T) {
+	var gzipped bytes.Buffer
+	gzw := gzip.NewWriter(&gzipped)
+	io.WriteString(gzw, `{"id":"1","seatbid":[{"bid":[{"id":"b1","impid":"1","price":1}]}]}`)
+	gzw.Close()
+
+	tests := []struct {
+		name     string
+		status   int
+		encoding string
+		body     string
+		want     string
+	}{
+		{name: "bid", status: http.StatusOK, body: `{"id":"1","seatbid":[{"bid":[{"id":"b1","impid":"1","price":1}]}]}`, want: OutcomeBid},
+		{name: "gzip bid", status: http.StatusOK, encoding: "gzip", body: gzipped.String(), want: OutcomeBid},
+		{name: "no content", status: http.StatusNoContent, want: OutcomeNoBid},
+		{name: "empty body", status: http.StatusOK, want: OutcomeNoBid},
+		{name: "no seat bids", status: http.StatusOK, body: `{"id":"1","nbr":2}`, want: OutcomeNoBid},
+		{name: "server error", status: http.StatusInternalServerError, body: `{"id":"1"}`, want: OutcomeHTTPError},
+		{name: "bad request", status: http.StatusBadRequest, body: "bad request", want: OutcomeHTTPError},
+		{name: "invalid json", status: http.StatusOK, body: "<html>", want: OutcomeDecodeError},
+		{name: "invalid gzip", status: http.StatusOK, encoding: "gzip", body: "not gzip", want: OutcomeDecodeError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &trackedBody{Reader: strings.NewReader(tt.body)}
+			res := &http.Response{StatusCode: tt.status, Header: http.Header{}, Body: body}
+			if tt.encoding != "" {
+				res.Header.Set("Content-Encoding", tt.encoding)
+			}
+
+			bidResponse, err := readResponse(res)
+			if got := responseOutcome(&bidResponse, err); got != tt.want {
+				t.Errorf("outcome = %s (err %v); want %s", got, err, tt.want)
+			}
+			if !body.closed || body.Len() != 0 {
+				t.Errorf("closed = %v, unread = %d; want the body drained and closed", body.closed, body.Len())
+			}
+		})
+	}
+}
+
+type trackedBody struct {
+	*strings.Reader
+	closed bool
+}
+
+func (b *trackedBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestResponseOutcome_Errors(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: context.DeadlineExceeded, want: OutcomeTimeout},
+		{err: &net.OpError{Op: "read", Err: timeoutError{}}, want: OutcomeTimeout},
+		{err: context.Canceled, want: OutcomeError},
+		{err: errors.New("connection refused"), want: OutcomeError},
+	}
+
+	for _, tt := range tests {
+		if got := responseOutcome(nil, tt.err); got != tt.want {
+			t.Errorf("outcome(%v) = %s; want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestDSPIO_ReusesConnections(t *testing.T) {
+	var conns atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		switch r.URL.Query().Get("status") {
+		case "204":
+			w.WriteHeader(http.StatusNoContent)
+		case "500":
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, strings.Repeat("error ", 100))
+		}
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	d := newTestDSPIO()
+	for _, status := range []string{"204", "500", "204", "500"} {
+		in := newTestIn(t, server.URL+"?status="+status, false, 0)
+		if _, err := d.attempt(t.Context(), in, "1"); (err != nil) != (status == "500") {
+			t.Errorf("status %s: err = %v", status, err)
+		}
+	}
+
+	if n := conns.Load(); n != 1 {
+		t.Errorf("connections = %d; want 1, reused across responses", n)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+
+	"perftest/libs/openrtb"
+)
+
+// Seats
+// Buyer seats restrict which DSPs receive a request and which bids are accepted back.
+// BidRequest.WSeat/BSeat apply to the open market; Deal.WSeat restricts the buyers of a deal.
+// A seat in BSeat is always blocked, even when a deal allows it.
+// --
+
+// seatAllowed reports whether the seat may bid in the open market of the request.
+func seatAllowed(request *openrtb.BidRequest, seat string) bool {
+	if slices.Contains(request.BSeat, seat) {
+		return false
+	}
+
+	return len(request.WSeat) == 0 || slices.Contains(request.WSeat, seat)
+}
+
+// dealSeatAllowed reports whether the seat may bid on the deal.
+// Deals without a seat allow list are open to every seat allowed by the request.
+func dealSeatAllowed(request *openrtb.BidRequest, deal *openrtb.Deal, seat string) bool {
+	if len(deal.WSeat) == 0 {
+		return seatAllowed(request, seat)
+	}
+
+	return !slices.Contains(request.BSeat, seat) && slices.Contains(deal.WSeat, seat)
+}
+
+// bidSeatAllowed reports whether the seat may place a bid, optionally on a deal.
+func bidSeatAllowed(request *openrtb.BidRequest, deal *openrtb.Deal, seat string) bool {
+	if deal != nil {
+		return dealSeatAllowed(request, deal, seat)
+	}
+
+	return seatAllowed(request, seat)
+}
+
+// dspSeatsAllowed reports whether any seat of the DSP may bid on the request,
+// either in the open market or on any deal offered by its impressions.
+// DSPs failing this check are not sent the request at all.
+func dspSeatsAllowed(request *openrtb.BidRequest, dsp *DSP) bool {
+	for _, seat := range dsp.Seats {
+		if seatAllowed(request, seat) {
+			return true
+		}
+
+		for i := range request.Imp {
+			pmp := request.Imp[i].PMP
+			if pmp == nil {
+				continue
+			}
+			for j := range pmp.Deals {
+				if dealSeatAllowed(request, &pmp.Deals[j], seat) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// findDeal returns the deal of the impression with the given ID, or nil.
+func findDeal(imp *openrtb.Imp, dealID string) *openrtb.Deal {
+	if dealID == "" || imp.PMP == nil {
+		return nil
+	}
+
+	for i := range imp.PMP.Deals {
+		if imp.PMP.Deals[i].ID == dealID {
+			return &imp.PMP.Deals[i]
+		}
+	}
+
+	return nil
+}
+
+// defaultSeat returns the seat of a DSP response without SeatBid.Seat: the first configured seat of the DSP,
+// or its ID when the DSP is unknown.
+func defaultSeat(dsp *DSP, dspID int) string {
+	if dsp != nil && len(dsp.Seats) > 0 {
+		return dsp.Seats[0]
+	}
+
+	return strconv.Itoa(dspID)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"perftest/libs/openrtb"
+)
+
+func TestDSPSeatsAllowed(t *testing.T) {
+	dsp := &DSP{ID: 1, Seats: []string{"s1", "s2"}}
+
+	tests := []struct {
+		name string
+		req  openrtb.BidRequest
+		want bool
+	}{
+		{"no lists", openrtb.BidRequest{}, true},
+		{"allowed by wseat", openrtb.BidRequest{WSeat: []string{"s2"}}, true},
+		{"not in wseat", openrtb.BidRequest{WSeat: []string{"other"}}, false},
+		{"one seat blocked", openrtb.BidRequest{BSeat: []string{"s1"}}, true},
+		{"all seats blocked", openrtb.BidRequest{BSeat: []string{"s1", "s2"}}, false},
+		{"allowed by deal", openrtb.BidRequest{
+			WSeat: []string{"other"},
+			Imp:   []openrtb.Imp{{PMP: &openrtb.PMP{Deals: []openrtb.Deal{{ID: "d1", WSeat: []string{"s1"}}}}}},
+		}, true},
+		{"deal seat blocked", openrtb.BidRequest{
+			WSeat: []string{"other"},
+			BSeat: []string{"s1"},
+			Imp:   []openrtb.Imp{{PMP: &openrtb.PMP{Deals: []openrtb.Deal{{ID: "d1", WSeat: []string{"s1"}}}}}},
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dspSeatsAllowed(&tt.req, dsp); got != tt.want {
+				t.Errorf("dspSeatsAllowed() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
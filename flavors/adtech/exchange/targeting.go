@@ -0,0 +1,142 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"perftest/libs/openrtb"
+)
+
+// Targeting
+// DSPs declare what they can buy (capabilities) and what they want to buy (targeting).
+// Before the fan-out, the exchange selects the DSPs eligible for the request and skips the others,
+// so DSPs are not sent requests they would never bid on.
+// Empty lists place no restriction. A restricted attribute missing from the request does not match.
+// --
+
+// Media types of a DSP capability.
+const (
+	MediaBanner = "banner"
+	MediaVideo  = "video"
+	MediaAudio  = "audio"
+	MediaNative = "native"
+)
+
+// Reasons a DSP is skipped during the fan-out.
+const (
+	SkipReasonSeat       = "seat"
+	SkipReasonMedia      = "media"
+	SkipReasonSize       = "size"
+	SkipReasonCountry    = "country"
+	SkipReasonPublisher  = "publisher"
+	SkipReasonOS         = "os"
+	SkipReasonDeviceType = "device_type"
+	SkipReasonAdapter    = "adapter"
+)
+
+// DSPCapabilities represents what a DSP is able to buy.
+// Countries are ISO-3166-1 alpha-3 codes, as in Geo.Country.
+type DSPCapabilities struct {
+	Media     []string     `json:"media,omitempty"`
+	Sizes     []BannerSize `json:"sizes,omitempty"`
+	Countries []string     `json:"countries,omitempty"`
+}
+
+// DSPTargeting represents what a DSP wants to buy.
+// DeviceTypes are OpenRTB device types (Device.DeviceType).
+type DSPTargeting struct {
+	Publishers        []int    `json:"publishers,omitempty"`
+	BlockedPublishers []int    `json:"blocked_publishers,omitempty"`
+	OS                []string `json:"os,omitempty"`
+	DeviceTypes       []int    `json:"device_types,omitempty"`
+}
+
+// dspSkipReason returns why the DSP is not eligible for the request, or "" if it is.
+// A DSP is eligible when it can buy at least one impression of the request.
+func dspSkipReason(request *openrtb.BidRequest, inv *Inventory, dsp *DSP) string {
+	if t := dsp.Targeting; t != nil {
+		pubID := inv.PublisherID()
+		if slices.Contains(t.BlockedPublishers, pubID) || (len(t.Publishers) > 0 && !slices.Contains(t.Publishers, pubID)) {
+			return SkipReasonPublisher
+		}
+
+		var device openrtb.Device
+		if request.Device != nil {
+			device = *request.Device
+		}
+		if len(t.OS) > 0 && !slices.ContainsFunc(t.OS, func(os string) bool { return strings.EqualFold(os, device.OS) }) {
+			return SkipReasonOS
+		}
+		if len(t.DeviceTypes) > 0 && !slices.Contains(t.DeviceTypes, device.DeviceType) {
+			return SkipReasonDeviceType
+		}
+	}
+
+	if c := dsp.Capabilities; c != nil {
+		if len(c.Countries) > 0 && !slices.Contains(c.Countries, requestCountry(request)) {
+			return SkipReasonCountry
+		}
+		if reason := impsSkipReason(request, c); reason != "" {
+			return reason
+		}
+	}
+
+	if !dspSeatsAllowed(request, dsp) {
+		return SkipReasonSeat
+	}
+
+	return ""
+}
+
+// impsSkipReason returns why the capabilities match none of the impressions, or "" if any matches.
+func impsSkipReason(request *openrtb.BidRequest, c *DSPCapabilities) string {
+	if len(c.Media) == 0 && len(c.Sizes) == 0 {
+		return ""
+	}
+
+	reason := SkipReasonMedia
+	for i := range request.Imp {
+		imp := &request.Imp[i]
+		if imp.Banner != nil && mediaSupported(c, MediaBanner) {
+			if sizeSupported(c, imp.Banner) {
+				return ""
+			}
+			reason = SkipReasonSize
+		}
+		if (imp.Video != nil && mediaSupported(c, MediaVideo)) ||
+			(imp.Audio != nil && mediaSupported(c, MediaAudio)) ||
+			(len(imp.Native) > 0 && mediaSupported(c, MediaNative)) {
+			return ""
+		}
+	}
+
+	return reason
+}
+
+func mediaSupported(c *DSPCapabilities, media string) bool {
+	return len(c.Media) == 0 || slices.Contains(c.Media, media)
+}
+
+// sizeSupported reports whether the banner size, or any of its alternative formats, is supported.
+func sizeSupported(c *DSPCapabilities, banner *openrtb.Banner) bool {
+	if len(c.Sizes) == 0 || slices.Contains(c.Sizes, BannerSize{W: banner.W, H: banner.H}) {
+		return true
+	}
+
+	for _, format := range banner.Format {
+		if slices.Contains(c.Sizes, BannerSize{W: format.W, H: format.H}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestCountry returns the country of the device, or "" if unknown.
+func requestCountry(request *openrtb.BidRequest) string {
+	if request.Device == nil || request.Device.Geo == nil {
+		return ""
+	}
+
+	return request.Device.Geo.Country
+}
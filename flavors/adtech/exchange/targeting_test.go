@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"perftest/libs/openrtb"
+)
+
+func TestDSPSkipReason(t *testing.T) {
+	inv := newTestAppInventory(100, 10)
+	banner := func(w, h int) openrtb.Imp { return openrtb.Imp{ID: "1", Banner: &openrtb.Banner{W: w, H: h}} }
+	request := func(imps ...openrtb.Imp) openrtb.BidRequest {
+		return openrtb.BidRequest{
+			Imp:    imps,
+			Device: &openrtb.Device{OS: "Android", DeviceType: 4, Geo: &openrtb.Geo{Country: "BRA"}},
+		}
+	}
+
+	tests := []struct {
+		name string
+		dsp  DSP
+		req  openrtb.BidRequest
+		want string
+	}{
+		{"no restrictions", DSP{}, request(banner(300, 250)), ""},
+		{"media", DSP{Capabilities: &DSPCapabilities{Media: []string{MediaVideo}}}, request(banner(300, 250)), SkipReasonMedia},
+		{"any imp matches", DSP{Capabilities: &DSPCapabilities{Media: []string{MediaVideo}}},
+			request(banner(300, 250), openrtb.Imp{ID: "2", Video: &openrtb.Video{}}), ""},
+		{"size", DSP{Capabilities: &DSPCapabilities{Sizes: []BannerSize{{W: 728, H: 90}}}}, request(banner(300, 250)), SkipReasonSize},
+		{"size by format", DSP{Capabilities: &DSPCapabilities{Sizes: []BannerSize{{W: 728, H: 90}}}},
+			request(openrtb.Imp{ID: "1", Banner: &openrtb.Banner{W: 300, H: 250, Format: []openrtb.Format{{W: 728, H: 90}}}}), ""},
+		{"country", DSP{Capabilities: &DSPCapabilities{Countries: []string{"USA"}}}, request(banner(300, 250)), SkipReasonCountry},
+		{"country matches", DSP{Capabilities: &DSPCapabilities{Countries: []string{"USA", "BRA"}}}, request(banner(300, 250)), ""},
+		{"unknown country", DSP{Capabilities: &DSPCapabilities{Countries: []string{"BRA"}}}, openrtb.BidRequest{Imp: []openrtb.Imp{banner(300, 250)}}, SkipReasonCountry},
+		{"publisher allow list", DSP{Targeting: &DSPTargeting{Publishers: []int{20}}}, request(banner(300, 250)), SkipReasonPublisher},
+		{"publisher deny list", DSP{Targeting: &DSPTargeting{BlockedPublishers: []int{10}}}, request(banner(300, 250)), SkipReasonPublisher},
+		{"os", DSP{Targeting: &DSPTargeting{OS: []string{"iOS"}}}, request(banner(300, 250)), SkipReasonOS},
+		{"os case insensitive", DSP{Targeting: &DSPTargeting{OS: []string{"android"}}}, request(banner(300, 250)), ""},
+		{"device type", DSP{Targeting: &DSPTargeting{DeviceTypes: []int{2}}}, request(banner(300, 250)), SkipReasonDeviceType},
+		{"seat", DSP{Seats: []string{"s1"}}, openrtb.BidRequest{Imp: []openrtb.Imp{banner(300, 250)}, BSeat: []string{"s1"}}, SkipReasonSeat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.dsp.Seats == nil {
+				tt.dsp.Seats = []string{"1"}
+			}
+			if got := dspSkipReason(&tt.req, inv, &tt.dsp); got != tt.want {
+				t.Errorf("dspSkipReason() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
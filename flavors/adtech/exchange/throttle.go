@@ -0,0 +1,219 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"perftest/libs/openrtb"
+)
+
+// Throttling
+// Each DSP may be capped at a contracted QPS, enforced by a token bucket before the request is enqueued.
+// Smart throttling optionally sheds traffic to DSPs that rarely bid for a publisher: once enough requests
+// were sent in a window, pairs with a bid rate below the minimum only receive a small exploration share,
+// so their bid rate can still recover.
+// Buckets and bid rates are keyed by DSP ID and survive cache reloads.
+// --
+
+// Reasons a DSP request is throttled.
+const (
+	ThrottleReasonQPS   = "qps"
+	ThrottleReasonSmart = "smart"
+)
+
+// TokenBucket is a token bucket rate limiter. It is safe for concurrent use.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a full token bucket refilled at rate tokens per second.
+// A burst lower than 1 defaults to one second worth of tokens.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	b := float64(burst)
+	if b < 1 {
+		b = max(math.Ceil(rate), 1)
+	}
+
+	return &TokenBucket{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+// Allow takes a token from the bucket, reporting whether one was available at now.
+func (b *TokenBucket) Allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed.Seconds()*b.rate, b.burst)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// Refund gives back a token taken for a request that was not sent.
+func (b *TokenBucket) Refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = min(b.tokens+1, b.burst)
+}
+
+// SmartThrottlingConfig configures smart throttling.
+type SmartThrottlingConfig struct {
+	Enabled bool
+	// Window is the period over which bid rates are measured.
+	Window time.Duration
+	// MinBidRate is the bid rate below which a DSP and publisher pair is throttled.
+	MinBidRate float64
+	// MinSamples is the number of requests a window needs before it is closed.
+	MinSamples int
+	// Explore is the share of requests still sent to throttled pairs.
+	Explore float64
+}
+
+type bidRateKey struct {
+	dspID int
+	pubID int
+}
+
+// bidRate measures the bid rate of a DSP and publisher pair, per window.
+type bidRate struct {
+	mu       sync.Mutex
+	start    time.Time
+	requests int
+	bids     int
+	// rate is the bid rate of the last complete window; -1 while unknown.
+	rate float64
+}
+
+// Throttler decides whether a request may be sent to a DSP. It is safe for concurrent use.
+type Throttler struct {
+	smart   SmartThrottlingConfig
+	buckets sync.Map // int -> *TokenBucket
+	rates   sync.Map // bidRateKey -> *bidRate
+}
+
+// NewThrottler creates a new throttler.
+func NewThrottler(smart SmartThrottlingConfig) *Throttler {
+	return &Throttler{smart: smart}
+}
+
+// Allow returns why the request to the DSP for the publisher is throttled, or "" if it may be sent.
+func (t *Throttler) Allow(dsp *DSP, pubID int, now time.Time) string {
+	if t.smart.Enabled && t.lowBidRate(dsp.ID, pubID, now) && rand.Float64() >= t.smart.Explore {
+		return ThrottleReasonSmart
+	}
+
+	if dsp.QPS > 0 && !t.bucket(dsp).Allow(now) {
+		return ThrottleReasonQPS
+	}
+
+	return ""
+}
+
+// Refund gives back the QPS token taken by Allow for a request to the DSP that could not be sent.
+func (t *Throttler) Refund(dsp *DSP) {
+	if dsp.QPS > 0 {
+		t.bucket(dsp).Refund()
+	}
+}
+
+// RecordRequest records that a request was sent to the DSP for the publisher.
+func (t *Throttler) RecordRequest(dspID, pubID int, now time.Time) {
+	if !t.smart.Enabled {
+		return
+	}
+
+	r := t.bidRate(dspID, pubID, now)
+	r.mu.Lock()
+	r.roll(now, t.smart)
+	r.requests++
+	r.mu.Unlock()
+}
+
+// RecordBid records that the DSP answered a request for the publisher with at least one bid.
+func (t *Throttler) RecordBid(dspID, pubID int, now time.Time) {
+	if !t.smart.Enabled {
+		return
+	}
+
+	r := t.bidRate(dspID, pubID, now)
+	r.mu.Lock()
+	r.roll(now, t.smart)
+	r.bids++
+	r.mu.Unlock()
+}
+
+func (t *Throttler) lowBidRate(dspID, pubID int, now time.Time) bool {
+	v, ok := t.rates.Load(bidRateKey{dspID: dspID, pubID: pubID})
+	if !ok {
+		return false
+	}
+
+	r := v.(*bidRate)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.roll(now, t.smart)
+
+	return r.rate >= 0 && r.rate < t.smart.MinBidRate
+}
+
+// bucket returns the token bucket of the DSP, replacing it when the DSP limits changed.
+func (t *Throttler) bucket(dsp *DSP) *TokenBucket {
+	if v, ok := t.buckets.Load(dsp.ID); ok {
+		b := v.(*TokenBucket)
+		if b.rate == dsp.QPS && (dsp.Burst < 1 || b.burst == float64(dsp.Burst)) {
+			return b
+		}
+	}
+
+	b := NewTokenBucket(dsp.QPS, dsp.Burst)
+	t.buckets.Store(dsp.ID, b)
+
+	return b
+}
+
+func (t *Throttler) bidRate(dspID, pubID int, now time.Time) *bidRate {
+	key := bidRateKey{dspID: dspID, pubID: pubID}
+	if v, ok := t.rates.Load(key); ok {
+		return v.(*bidRate)
+	}
+
+	v, _ := t.rates.LoadOrStore(key, &bidRate{start: now, rate: -1})
+	return v.(*bidRate)
+}
+
+// roll closes the current window once it is over and has enough samples.
+// Windows of throttled pairs grow until the exploration share gathers enough samples. Callers must hold r.mu.
+func (r *bidRate) roll(now time.Time, config SmartThrottlingConfig) {
+	if now.Sub(r.start) < config.Window || r.requests < config.MinSamples {
+		return
+	}
+
+	r.rate = float64(r.bids) / float64(r.requests)
+	r.start = now
+	r.requests = 0
+	r.bids = 0
+}
+
+// hasBids reports whether the response has at least one bid.
+func hasBids(response *openrtb.BidResponse) bool {
+	for i := range response.SeatBid {
+		if len(response.SeatBid[i].Bid) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Allow(t *testing.T) {
+	b := NewTokenBucket(10, 2)
+	now := b.last
+
+	if !b.Allow(now) || !b.Allow(now) {
+		t.Fatal("burst of 2 should be allowed")
+	}
+	if b.Allow(now) {
+		t.Fatal("third request should be throttled")
+	}
+	if !b.Allow(now.Add(100 * time.Millisecond)) {
+		t.Error("a token should be refilled after 100ms at 10 QPS")
+	}
+	if b.Allow(now.Add(100 * time.Millisecond)) {
+		t.Error("only one token should be refilled")
+	}
+	if !b.Allow(now.Add(time.Hour)) || !b.Allow(now.Add(time.Hour)) || b.Allow(now.Add(time.Hour)) {
+		t.Error("refill should be capped at the burst")
+	}
+}
+
+func TestThrottler_QPS(t *testing.T) {
+	throttler := NewThrottler(SmartThrottlingConfig{})
+	dsp := &DSP{ID: 1, QPS: 1}
+	now := time.Now()
+
+	if got := throttler.Allow(dsp, 10, now); got != "" {
+		t.Fatalf("first request throttled: %q", got)
+	}
+	if got := throttler.Allow(dsp, 10, now); got != ThrottleReasonQPS {
+		t.Errorf("second request = %q; want %q", got, ThrottleReasonQPS)
+	}
+	throttler.Refund(dsp)
+	if got := throttler.Allow(dsp, 10, now); got != "" {
+		t.Errorf("request after a refund throttled: %q", got)
+	}
+	if got := throttler.Allow(&DSP{ID: 2}, 10, now); got != "" {
+		t.Errorf("DSP without QPS throttled: %q", got)
+	}
+}
+
+func TestThrottler_Smart(t *testing.T) {
+	throttler := NewThrottler(SmartThrottlingConfig{Enabled: true, Window: time.Minute, MinBidRate: 0.5, MinSamples: 10})
+	dsp := &DSP{ID: 1}
+	now := time.Now()
+
+	for range 10 {
+		throttler.RecordRequest(dsp.ID, 10, now)
+		throttler.RecordRequest(dsp.ID, 20, now)
+		throttler.RecordBid(dsp.ID, 20, now)
+	}
+	throttler.RecordBid(dsp.ID, 10, now)
+
+	if got := throttler.Allow(dsp, 10, now); got != "" {
+		t.Errorf("throttled before the window is over: %q", got)
+	}
+
+	later := now.Add(time.Minute)
+	if got := throttler.Allow(dsp, 10, later); got != ThrottleReasonSmart {
+		t.Errorf("low bid rate publisher = %q; want %q", got, ThrottleReasonSmart)
+	}
+	if got := throttler.Allow(dsp, 20, later); got != "" {
+		t.Errorf("high bid rate publisher throttled: %q", got)
+	}
+	if got := throttler.Allow(dsp, 30, later); got != "" {
+		t.Errorf("unknown publisher throttled: %q", got)
+	}
+}
@@ -40,3 +40,12 @@ func GetDuration(key string, defaultValue time.Duration) (time.Duration, error)
 
 	return time.ParseDuration(value)
 }
+
+func GetFloat(key string, defaultValue float64) (float64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	return strconv.ParseFloat(value, 64)
+}
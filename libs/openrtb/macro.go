@@ -0,0 +1,173 @@
+package openrtb
+
+import (
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// Substitution macros.
+// Spec: OpenRTB API Specification Version 2.5, section 4.4 (IAB Tech Lab);
+// ${AUCTION_MIN_TO_WIN} is from Version 2.6, section 4.4.
+// Macros are substituted by the exchange in Bid.Adm, Bid.NURL, Bid.BURL and Bid.IURL.
+const (
+	MacroAuctionID       = "${AUCTION_ID}"
+	MacroAuctionBidID    = "${AUCTION_BID_ID}"
+	MacroAuctionImpID    = "${AUCTION_IMP_ID}"
+	MacroAuctionSeatID   = "${AUCTION_SEAT_ID}"
+	MacroAuctionAdID     = "${AUCTION_AD_ID}"
+	MacroAuctionPrice    = "${AUCTION_PRICE}"
+	MacroAuctionCurrency = "${AUCTION_CURRENCY}"
+	MacroAuctionMBR      = "${AUCTION_MBR}"
+	MacroAuctionLoss     = "${AUCTION_LOSS}"
+	MacroAuctionMinToWin = "${AUCTION_MIN_TO_WIN}"
+)
+
+// MacroValues holds the values substituted for the auction macros.
+type MacroValues struct {
+	AuctionID string
+	BidID     string
+	ImpID     string
+	SeatID    string
+	AdID      string
+	// Price is the clearing price, substituted for ${AUCTION_PRICE}.
+	Price    float64
+	Currency string
+	// MBR is the market bid ratio (clearing price / bid price).
+	MBR float64
+	// Loss is the loss reason code; 0 means the bid won.
+	Loss int
+	// MinToWin is the minimum bid that would have won the auction.
+	MinToWin float64
+}
+
+// PriceEncrypter encrypts the clearing price substituted for ${AUCTION_PRICE}.
+// Implementations append the encoded price to dst and return the extended buffer.
+// The encoded price is substituted as is, so it must be URL safe (e.g. web-safe base64).
+type PriceEncrypter interface {
+	AppendEncryptedPrice(dst []byte, price float64) []byte
+}
+
+// PriceEncrypterFunc adapts a function to the PriceEncrypter interface.
+type PriceEncrypterFunc func(dst []byte, price float64) []byte
+
+// AppendEncryptedPrice calls f(dst, price).
+func (f PriceEncrypterFunc) AppendEncryptedPrice(dst []byte, price float64) []byte {
+	return f(dst, price)
+}
+
+// MacroExpander substitutes the auction macros in a single pass over the input.
+// Unknown macros are left untouched. A MacroExpander is safe for concurrent use.
+type MacroExpander struct {
+	// URLEscape query-escapes the substituted values. Use it when expanding URLs.
+	URLEscape bool
+	// PriceEncrypter, when set, encrypts the price substituted for ${AUCTION_PRICE}.
+	PriceEncrypter PriceEncrypter
+}
+
+// Expand returns s with the auction macros substituted.
+// When s has no macros, it is returned as is without allocating.
+func (e *MacroExpander) Expand(s string, v *MacroValues) string {
+	i := strings.Index(s, "${")
+	if i == -1 {
+		return s
+	}
+
+	buf := make([]byte, 0, len(s)+64)
+	buf = e.appendExpand(buf, s, i, v)
+
+	return unsafe.String(unsafe.SliceData(buf), len(buf))
+}
+
+// AppendExpand appends s with the auction macros substituted to dst and returns the extended buffer.
+func (e *MacroExpander) AppendExpand(dst []byte, s string, v *MacroValues) []byte {
+	i := strings.Index(s, "${")
+	if i == -1 {
+		return append(dst, s...)
+	}
+
+	return e.appendExpand(dst, s, i, v)
+}
+
+// appendExpand does the actual substitution. i is the index of the first "${" in s.
+func (e *MacroExpander) appendExpand(dst []byte, s string, i int, v *MacroValues) []byte {
+	for i != -1 {
+		dst = append(dst, s[:i]...)
+		s = s[i:]
+
+		end := strings.IndexByte(s, '}')
+		if end == -1 {
+			break
+		}
+		// A "${" before the closing brace starts the actual macro: the text up to it is copied as is.
+		if inner := strings.Index(s[2:end], "${"); inner != -1 {
+			dst = append(dst, s[:inner+2]...)
+			s = s[inner+2:]
+			i = 0
+			continue
+		}
+
+		macro := s[:end+1]
+		s = s[end+1:]
+
+		switch macro {
+		case MacroAuctionID:
+			dst = e.appendString(dst, v.AuctionID)
+		case MacroAuctionBidID:
+			dst = e.appendString(dst, v.BidID)
+		case MacroAuctionImpID:
+			dst = e.appendString(dst, v.ImpID)
+		case MacroAuctionSeatID:
+			dst = e.appendString(dst, v.SeatID)
+		case MacroAuctionAdID:
+			dst = e.appendString(dst, v.AdID)
+		case MacroAuctionPrice:
+			if e.PriceEncrypter != nil {
+				dst = e.PriceEncrypter.AppendEncryptedPrice(dst, v.Price)
+			} else {
+				dst = strconv.AppendFloat(dst, v.Price, 'f', -1, 64)
+			}
+		case MacroAuctionCurrency:
+			dst = e.appendString(dst, v.Currency)
+		case MacroAuctionMBR:
+			dst = strconv.AppendFloat(dst, v.MBR, 'f', -1, 64)
+		case MacroAuctionLoss:
+			dst = strconv.AppendInt(dst, int64(v.Loss), 10)
+		case MacroAuctionMinToWin:
+			dst = strconv.AppendFloat(dst, v.MinToWin, 'f', -1, 64)
+		default:
+			dst = append(dst, macro...)
+		}
+
+		i = strings.Index(s, "${")
+	}
+
+	return append(dst, s...)
+}
+
+func (e *MacroExpander) appendString(dst []byte, s string) []byte {
+	if !e.URLEscape {
+		return append(dst, s...)
+	}
+
+	return appendQueryEscape(dst, s)
+}
+
+// appendQueryEscape appends s escaped like url.QueryEscape, without the intermediate string.
+func appendQueryEscape(dst []byte, s string) []byte {
+	const hex = "0123456789ABCDEF"
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			dst = append(dst, c)
+		case c == ' ':
+			dst = append(dst, '+')
+		default:
+			dst = append(dst, '%', hex[c>>4], hex[c&15])
+		}
+	}
+
+	return dst
+}
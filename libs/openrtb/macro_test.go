@@ -0,0 +1,219 @@
+package openrtb
+
+import (
+	"encoding/base64"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var testMacroValues = MacroValues{
+	AuctionID: "auction-1",
+	BidID:     "bid-1",
+	ImpID:     "imp-1",
+	SeatID:    "seat 1",
+	AdID:      "ad-1",
+	Price:     1.25,
+	Currency:  "USD",
+	MBR:       0.5,
+	Loss:      102,
+	MinToWin:  1.01,
+}
+
+func TestMacroExpander_Expand(t *testing.T) {
+	e := &MacroExpander{}
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"no macros", "https://dsp/win", "https://dsp/win"},
+		{"all macros",
+			"${AUCTION_ID}|${AUCTION_BID_ID}|${AUCTION_IMP_ID}|${AUCTION_SEAT_ID}|${AUCTION_AD_ID}|${AUCTION_PRICE}|${AUCTION_CURRENCY}|${AUCTION_MBR}|${AUCTION_LOSS}|${AUCTION_MIN_TO_WIN}",
+			"auction-1|bid-1|imp-1|seat 1|ad-1|1.25|USD|0.5|102|1.01"},
+		{"repeated macro", "${AUCTION_PRICE}-${AUCTION_PRICE}", "1.25-1.25"},
+		{"unknown macro", "a=${UNKNOWN}&p=${AUCTION_PRICE}", "a=${UNKNOWN}&p=1.25"},
+		{"unterminated macro", "p=${AUCTION_PRICE}&x=${AUCTION", "p=1.25&x=${AUCTION"},
+		{"unterminated macro before macro", "${foo ${AUCTION_ID}", "${foo auction-1"},
+		{"dollar without brace", "$5 ${AUCTION_CURRENCY}", "$5 USD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := e.Expand(tt.in, &testMacroValues); got != tt.want {
+				t.Errorf("Expand(%q) = %q; want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMacroExpander_URLEscape(t *testing.T) {
+	e := &MacroExpander{URLEscape: true}
+	v := testMacroValues
+	v.AuctionID = "a&b=c/ü"
+
+	got := e.Expand("id=${AUCTION_ID}&seat=${AUCTION_SEAT_ID}", &v)
+	want := "id=" + url.QueryEscape(v.AuctionID) + "&seat=" + url.QueryEscape(v.SeatID)
+	if got != want {
+		t.Errorf("Expand() = %q; want %q", got, want)
+	}
+}
+
+func TestMacroExpander_PriceEncrypter(t *testing.T) {
+	e := &MacroExpander{PriceEncrypter: PriceEncrypterFunc(func(dst []byte, price float64) []byte {
+		return base64.RawURLEncoding.AppendEncode(dst, strconv.AppendFloat(nil, price, 'f', -1, 64))
+	})}
+
+	got := e.Expand("p=${AUCTION_PRICE}", &testMacroValues)
+	want := "p=" + base64.RawURLEncoding.EncodeToString([]byte("1.25"))
+	if got != want {
+		t.Errorf("Expand() = %q; want %q", got, want)
+	}
+}
+
+func TestMacroExpander_AppendExpand(t *testing.T) {
+	e := &MacroExpander{}
+	got := e.AppendExpand([]byte("prefix:"), "${AUCTION_IMP_ID}", &testMacroValues)
+	if string(got) != "prefix:imp-1" {
+		t.Errorf("AppendExpand() = %q; want %q", got, "prefix:imp-1")
+	}
+}
+
+func TestMacroExpander_NoMacrosDoesNotAllocate(t *testing.T) {
+	e := &MacroExpander{}
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = e.Expand("https://dsp/win?id=1", &testMacroValues)
+	})
+	if allocs != 0 {
+		t.Errorf("allocs = %v; want 0", allocs)
+	}
+}
+
+// Benchmarks
+// Compare the single pass expander against the usual strings.ReplaceAll chain and a strings.Replacer.
+// --
+
+const benchmarkNURL = "https://dsp.example.com/win?id=${AUCTION_ID}&bid=${AUCTION_BID_ID}&imp=${AUCTION_IMP_ID}" +
+	"&seat=${AUCTION_SEAT_ID}&ad=${AUCTION_AD_ID}&price=${AUCTION_PRICE}&cur=${AUCTION_CURRENCY}"
+
+var benchmarkAdm = `<div><a href="https://adv.example.com/click?id=${AUCTION_ID}"><img src="https://cdn.example.com/creative.png"/></a>` +
+	`<img src="https://dsp.example.com/imp?price=${AUCTION_PRICE}&imp=${AUCTION_IMP_ID}" width="1" height="1"/>` +
+	strings.Repeat(`<p>lorem ipsum dolor sit amet</p>`, 20) + `</div>`
+
+func expandNaive(s string, v *MacroValues) string {
+	s = strings.ReplaceAll(s, MacroAuctionID, v.AuctionID)
+	s = strings.ReplaceAll(s, MacroAuctionBidID, v.BidID)
+	s = strings.ReplaceAll(s, MacroAuctionImpID, v.ImpID)
+	s = strings.ReplaceAll(s, MacroAuctionSeatID, v.SeatID)
+	s = strings.ReplaceAll(s, MacroAuctionAdID, v.AdID)
+	s = strings.ReplaceAll(s, MacroAuctionPrice, strconv.FormatFloat(v.Price, 'f', -1, 64))
+	s = strings.ReplaceAll(s, MacroAuctionCurrency, v.Currency)
+	s = strings.ReplaceAll(s, MacroAuctionMBR, strconv.FormatFloat(v.MBR, 'f', -1, 64))
+	s = strings.ReplaceAll(s, MacroAuctionLoss, strconv.Itoa(v.Loss))
+	s = strings.ReplaceAll(s, MacroAuctionMinToWin, strconv.FormatFloat(v.MinToWin, 'f', -1, 64))
+	return s
+}
+
+func expandReplacer(s string, v *MacroValues) string {
+	return strings.NewReplacer(
+		MacroAuctionID, v.AuctionID,
+		MacroAuctionBidID, v.BidID,
+		MacroAuctionImpID, v.ImpID,
+		MacroAuctionSeatID, v.SeatID,
+		MacroAuctionAdID, v.AdID,
+		MacroAuctionPrice, strconv.FormatFloat(v.Price, 'f', -1, 64),
+		MacroAuctionCurrency, v.Currency,
+		MacroAuctionMBR, strconv.FormatFloat(v.MBR, 'f', -1, 64),
+		MacroAuctionLoss, strconv.Itoa(v.Loss),
+		MacroAuctionMinToWin, strconv.FormatFloat(v.MinToWin, 'f', -1, 64),
+	).Replace(s)
+}
+
+func TestExpandNaive_MatchesExpander(t *testing.T) {
+	e := &MacroExpander{}
+	for _, s := range []string{benchmarkNURL, benchmarkAdm} {
+		if got, want := e.Expand(s, &testMacroValues), expandNaive(s, &testMacroValues); got != want {
+			t.Errorf("Expand() = %q; naive = %q", got, want)
+		}
+		if got, want := e.Expand(s, &testMacroValues), expandReplacer(s, &testMacroValues); got != want {
+			t.Errorf("Expand() = %q; replacer = %q", got, want)
+		}
+	}
+}
+
+func BenchmarkExpand_NURL_ReplaceAll(b *testing.B) {
+	var out string
+	b.ReportAllocs()
+	for b.Loop() {
+		out = expandNaive(benchmarkNURL, &testMacroValues)
+	}
+	runtime.KeepAlive(out)
+}
+
+func BenchmarkExpand_NURL_Replacer(b *testing.B) {
+	var out string
+	b.ReportAllocs()
+	for b.Loop() {
+		out = expandReplacer(benchmarkNURL, &testMacroValues)
+	}
+	runtime.KeepAlive(out)
+}
+
+func BenchmarkExpand_NURL_SinglePass(b *testing.B) {
+	e := &MacroExpander{}
+	var out string
+	b.ReportAllocs()
+	for b.Loop() {
+		out = e.Expand(benchmarkNURL, &testMacroValues)
+	}
+	runtime.KeepAlive(out)
+}
+
+func BenchmarkExpand_NURL_SinglePassAppend(b *testing.B) {
+	e := &MacroExpander{}
+	buf := make([]byte, 0, 512)
+	b.ReportAllocs()
+	for b.Loop() {
+		buf = e.AppendExpand(buf[:0], benchmarkNURL, &testMacroValues)
+	}
+	runtime.KeepAlive(buf)
+}
+
+func BenchmarkExpand_Adm_ReplaceAll(b *testing.B) {
+	var out string
+	b.ReportAllocs()
+	for b.Loop() {
+		out = expandNaive(benchmarkAdm, &testMacroValues)
+	}
+	runtime.KeepAlive(out)
+}
+
+func BenchmarkExpand_Adm_Replacer(b *testing.B) {
+	var out string
+	b.ReportAllocs()
+	for b.Loop() {
+		out = expandReplacer(benchmarkAdm, &testMacroValues)
+	}
+	runtime.KeepAlive(out)
+}
+
+func BenchmarkExpand_Adm_SinglePass(b *testing.B) {
+	e := &MacroExpander{}
+	var out string
+	b.ReportAllocs()
+	for b.Loop() {
+		out = e.Expand(benchmarkAdm, &testMacroValues)
+	}
+	runtime.KeepAlive(out)
+}
+
+func BenchmarkExpand_NoMacros_SinglePass(b *testing.B) {
+	e := &MacroExpander{}
+	var out string
+	b.ReportAllocs()
+	for b.Loop() {
+		out = e.Expand("https://dsp.example.com/win?id=1", &testMacroValues)
+	}
+	runtime.KeepAlive(out)
+}
@@ -350,6 +350,7 @@ type Bid struct {
 	Price      float64         `json:"price"`
 	AdID       string          `json:"adid,omitempty"`
 	NURL       string          `json:"nurl,omitempty"`
+	BURL       string          `json:"burl,omitempty"` // Billing notice URL, introduced in OpenRTB 2.5.
 	Adm        string          `json:"adm,omitempty"`
 	Adomain    []string        `json:"adomain,omitempty"`
 	Bundle     string          `json:"bundle,omitempty"`
@@ -366,3 +367,18 @@ type Bid struct {
 	W          int             `json:"w,omitempty"`
 	Ext        json.RawMessage `json:"ext,omitempty"`
 }
+
+// No-bid reason codes for BidResponse.NBR.
+// Spec: OpenRTB API Specification Version 2.5, section 5.24 (IAB Tech Lab).
+// Values of 500 and above are exchange specific.
+const (
+	NBRUnknownError           = 0
+	NBRTechnicalError         = 1
+	NBRInvalidRequest         = 2
+	NBRKnownWebSpider         = 3
+	NBRSuspectedNonHuman      = 4
+	NBRCloudDataCenterProxyIP = 5
+	NBRUnsupportedDevice      = 6
+	NBRBlockedPublisher       = 7
+	NBRUnmatchedUser          = 8
+)
@@ -0,0 +1,83 @@
+package openrtb
+
+import (
+	"strconv"
+)
+
+// Bid request validation.
+// Spec: OpenRTB API Specification Version 2.5, section 3.2 (IAB Tech Lab).
+// Validate checks the fields the spec requires, so malformed requests can be rejected
+// before any work is done on them.
+
+// Validation error codes.
+const (
+	ValidationMissingID        = "missing_id"
+	ValidationMissingImp       = "missing_imp"
+	ValidationMissingImpID     = "missing_imp_id"
+	ValidationDuplicateImpID   = "duplicate_imp_id"
+	ValidationMissingImpFormat = "missing_imp_format"
+	ValidationMissingSiteOrApp = "missing_site_or_app"
+	ValidationSiteAndApp       = "site_and_app"
+)
+
+// ValidationError describes why a bid request is invalid.
+type ValidationError struct {
+	// Code is one of the Validation* codes, stable enough to be used as a metric label.
+	Code string `json:"code"`
+	// Field is the path of the offending field, e.g. "imp[1].id".
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return "openrtb: " + e.Message
+	}
+
+	return "openrtb: " + e.Field + ": " + e.Message
+}
+
+// Validate checks the bid request against the spec and returns the first problem found as a *ValidationError.
+// An impression must offer at least one of banner, video, audio or native.
+func (r *BidRequest) Validate() error {
+	if r.ID == "" {
+		return &ValidationError{Code: ValidationMissingID, Field: "id", Message: "bid request id is required"}
+	}
+	if len(r.Imp) == 0 {
+		return &ValidationError{Code: ValidationMissingImp, Field: "imp", Message: "at least one impression is required"}
+	}
+
+	switch {
+	case r.Site == nil && r.App == nil:
+		return &ValidationError{Code: ValidationMissingSiteOrApp, Message: "one of site or app is required"}
+	case r.Site != nil && r.App != nil:
+		return &ValidationError{Code: ValidationSiteAndApp, Message: "site and app must not be both present"}
+	}
+
+	for i := range r.Imp {
+		imp := &r.Imp[i]
+		if imp.ID == "" {
+			return &ValidationError{Code: ValidationMissingImpID, Field: impField(i, "id"), Message: "impression id is required"}
+		}
+		// Requests have a handful of impressions; a quadratic scan beats allocating a set.
+		for j := range i {
+			if r.Imp[j].ID == imp.ID {
+				return &ValidationError{Code: ValidationDuplicateImpID, Field: impField(i, "id"), Message: "impression id " + imp.ID + " is not unique"}
+			}
+		}
+		if imp.Banner == nil && imp.Video == nil && imp.Audio == nil && len(imp.Native) == 0 {
+			return &ValidationError{Code: ValidationMissingImpFormat, Field: impField(i, ""), Message: "impression has no banner, video, audio or native object"}
+		}
+	}
+
+	return nil
+}
+
+func impField(i int, field string) string {
+	path := "imp[" + strconv.Itoa(i) + "]"
+	if field == "" {
+		return path
+	}
+
+	return path + "." + field
+}
@@ -0,0 +1,53 @@
+package openrtb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBidRequest_Validate(t *testing.T) {
+	banner := &Banner{}
+	valid := func() *BidRequest {
+		return &BidRequest{ID: "r1", App: &App{ID: "1"}, Imp: []Imp{{ID: "1", Banner: banner}, {ID: "2", Video: &Video{}}}}
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(r *BidRequest)
+		code   string
+		field  string
+	}{
+		{"valid", func(r *BidRequest) {}, "", ""},
+		{"valid site", func(r *BidRequest) { r.App, r.Site = nil, &Site{ID: "1"} }, "", ""},
+		{"valid native", func(r *BidRequest) { r.Imp[0] = Imp{ID: "1", Native: []byte(`{}`)} }, "", ""},
+		{"missing id", func(r *BidRequest) { r.ID = "" }, ValidationMissingID, "id"},
+		{"missing imp", func(r *BidRequest) { r.Imp = nil }, ValidationMissingImp, "imp"},
+		{"missing site or app", func(r *BidRequest) { r.App = nil }, ValidationMissingSiteOrApp, ""},
+		{"site and app", func(r *BidRequest) { r.Site = &Site{ID: "1"} }, ValidationSiteAndApp, ""},
+		{"missing imp id", func(r *BidRequest) { r.Imp[1].ID = "" }, ValidationMissingImpID, "imp[1].id"},
+		{"duplicate imp id", func(r *BidRequest) { r.Imp[1].ID = "1" }, ValidationDuplicateImpID, "imp[1].id"},
+		{"missing imp format", func(r *BidRequest) { r.Imp[0].Banner = nil }, ValidationMissingImpFormat, "imp[0]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid()
+			tt.mutate(r)
+
+			err := r.Validate()
+			if tt.code == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v; want nil", err)
+				}
+				return
+			}
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Validate() = %v; want *ValidationError", err)
+			}
+			if verr.Code != tt.code || verr.Field != tt.field {
+				t.Errorf("Validate() = {%s %s}; want {%s %s}", verr.Code, verr.Field, tt.code, tt.field)
+			}
+		})
+	}
+}
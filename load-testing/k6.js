@@ -16,6 +16,22 @@ const SLEEP_SECONDS = Number(__ENV.SLEEP_SECONDS) || 0.1
 const MIN_ID = Number(__ENV.MIN_ID) || 1250
 const MAX_PUBLISHER_ID = Number(__ENV.MAX_PUBLISHER_ID) || 1749   // 1250 + 500 - 1
 const MAX_APP_ID = Number(__ENV.MAX_APP_ID) || 501249             // 1250 + 500000 - 1
+const MAX_SITE_ID = Number(__ENV.MAX_SITE_ID) || 101249           // 1250 + 100000 - 1
+
+// Share of requests sent as site (web) inventory instead of app inventory, between 0 and 1.
+const SITE_RATIO = Number(__ENV.SITE_RATIO) || 0
+
+// Impressions per request (inclusive); each request carries a random count in this range.
+const MIN_IMPS = Number(__ENV.MIN_IMPS) || 1
+const MAX_IMPS = Number(__ENV.MAX_IMPS) || MIN_IMPS
+
+// Banner sizes rotated across impressions.
+const BANNER_SIZES = [
+  { w: 200, h: 200 },
+  { w: 300, h: 250 },
+  { w: 320, h: 50 },
+  { w: 728, h: 90 },
+]
 
 export const options = {
   vus: VUS,
@@ -36,33 +52,32 @@ function randIntInclusive(min, max) {
   return Math.floor(Math.random() * (hi - lo + 1)) + lo
 }
 
-function makeBidRequest({ appId, publisherId }) {
+function makeImps(count) {
+  const imps = []
+  for (let i = 0; i < count; i++) {
+    imps.push({
+      id: String(i + 1),
+      banner: BANNER_SIZES[i % BANNER_SIZES.length],
+      bidfloor: 0.01,
+      bidfloorcur: 'USD',
+      secure: 0,
+    })
+  }
+  return imps
+}
+
+function makeBidRequest({ inventory, inventoryId, publisherId, impCount }) {
   const requestID = `k6-${exec.vu.idInTest}-${__ITER}-${Date.now()}`
 
+  const publisher = {
+    id: String(publisherId),
+    name: 'k6-publisher',
+  }
+
   // Mirrors `internal/openrtb/openrtb.go` JSON tags (OpenRTB 2.1-ish).
-  return {
+  const request = {
     id: requestID,
-    imp: [
-      {
-        id: '1',
-        banner: { w: 200, h: 200 },
-        bidfloor: 0.01,
-        bidfloorcur: 'USD',
-        secure: 0,
-      },
-    ],
-    app: {
-      id: String(appId), // exchange expects numeric string (strconv.Atoi)
-      name: 'k6-load-test',
-      domain: 'example.com',
-      bundle: 'com.example.k6',
-      publisher: {
-        id: String(publisherId),
-        name: 'k6-publisher',
-      },
-      privacypolicy: 0,
-      paid: 0,
-    },
+    imp: makeImps(impCount),
     device: {
       ua: 'k6',
       ip: '127.0.0.1',
@@ -78,14 +93,40 @@ function makeBidRequest({ appId, publisherId }) {
     test: 0,
     cur: ['USD'],
   }
+
+  // The exchange expects numeric inventory ids (strconv.Atoi).
+  if (inventory === 'site') {
+    request.site = {
+      id: String(inventoryId),
+      name: 'k6-load-test',
+      domain: 'example.com',
+      page: 'https://example.com/k6',
+      publisher,
+      privacypolicy: 0,
+    }
+  } else {
+    request.app = {
+      id: String(inventoryId),
+      name: 'k6-load-test',
+      domain: 'example.com',
+      bundle: 'com.example.k6',
+      publisher,
+      privacypolicy: 0,
+      paid: 0,
+    }
+  }
+
+  return request
 }
 
 export default function () {
   const publisherId = randIntInclusive(MIN_ID, MAX_PUBLISHER_ID)
-  const appId = randIntInclusive(MIN_ID, MAX_APP_ID)
+  const inventory = Math.random() < SITE_RATIO ? 'site' : 'app'
+  const inventoryId = randIntInclusive(MIN_ID, inventory === 'site' ? MAX_SITE_ID : MAX_APP_ID)
+  const impCount = randIntInclusive(MIN_IMPS, MAX_IMPS)
 
   const url = `${BASE_URL}${AD_PATH}`
-  const payload = JSON.stringify(makeBidRequest({ appId, publisherId }))
+  const payload = JSON.stringify(makeBidRequest({ inventory, inventoryId, publisherId, impCount }))
 
   const res = http.post(url, payload, {
     // k6 will gzip the body when compression is set.
@@ -94,12 +135,12 @@ export default function () {
       'Content-Type': 'application/json',
       'Content-Encoding': 'gzip',
     },
-    tags: { endpoint: 'ad' },
+    tags: { endpoint: 'ad', imps: String(impCount), inventory },
     timeout: '2s',
   })
 
   check(res, {
-    '200 (OK)': r => r.status === 200,
+    '200 (OK) or 204 (no bid)': r => r.status === 200 || r.status === 204,
   })
 
   sleep(SLEEP_SECONDS)
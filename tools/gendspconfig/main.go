@@ -1,6 +1,7 @@
 // gendspconfig generates d/dsps.json from DSP_COUNT (.env or --count).
 // Each DSP entry's latency is read from d/dsp-latencies.json (array by index); missing index → "0".
-// Usage: gendspconfig [--count <N>] [--out-dsps <path>] [--latencies <path>] [--env <path>]
+// With --profiles, DSPs are assigned capability and targeting profiles, rotated by index.
+// Usage: gendspconfig [--count <N>] [--out-dsps <path>] [--latencies <path>] [--env <path>] [--profiles] [--qps <N>] [--hedge] [--retries <N>] [--workers <N>] [--queue <N>] [--compression <name>] [--cleartext]
 package main
 
 import (
@@ -14,11 +15,12 @@ import (
 )
 
 const (
-	defaultCount = 25
-	projectName  = "adtech"
-	dspService   = "dsp"
-	dspPort      = 8080
-	bidPath      = "/bid"
+	defaultCount   = 25
+	projectName    = "adtech"
+	dspService     = "dsp"
+	dspPort        = 8080
+	cleartextPort  = 8081
+	bidPath        = "/bid"
 	defaultLatency = "0"
 )
 
@@ -53,18 +55,27 @@ func loadEnv(path string) map[string]string {
 func usage() {
 	fmt.Fprintf(os.Stderr, `
 Usage:
-  gendspconfig [--count <N>] [--out-dsps <path>] [--latencies <path>] [--env <path>]
+  gendspconfig [--count <N>] [--out-dsps <path>] [--latencies <path>] [--env <path>] [--profiles] [--qps <N>] [--hedge] [--retries <N>] [--workers <N>] [--queue <N>] [--compression <name>] [--cleartext]
 
 Options:
   --count      Number of DSPs (default: from .env DSP_COUNT or %d)
   --out-dsps   Output path for dsps.json (default: d/dsps.json)
   --latencies  Path to dsp-latencies.json array (default: d/dsp-latencies.json); index = DSP index 1..n, missing → "0"
   --env        Path to .env file (default: .env in cwd)
+  --profiles   Assign capability and targeting profiles (open, display, video, mobile, regional), rotated by DSP index
+  --qps        QPS cap of every DSP, enforced by the exchange (default: 0, no cap)
+  --hedge      Opt every DSP in to hedged requests
+  --retries    Retries on connection-level errors for every DSP (default: 0)
+  --workers    Workers of every DSP in the exchange (default: 0, the exchange default)
+  --queue      Queue size of every DSP in the exchange (default: 0, the exchange default)
+  --compression Compression of the requests sent to every DSP: none, gzip, zstd or brotli (default: gzip)
+  --cleartext  Use the cleartext http:// endpoints of the DSPs, for the http1 and h2c exchange clients
   --help       Show this help
 
 Examples:
   gendspconfig
   gendspconfig --count 10 --out-dsps d/dsps.json --latencies d/dsp-latencies.json
+  gendspconfig --profiles
 `, defaultCount)
 }
 
@@ -86,10 +97,69 @@ func loadLatencies(path string) []string {
 }
 
 type DSPEntry struct {
-	ID       int    `json:"id"`
-	Name     string `json:"name"`
-	Endpoint string `json:"endpoint"`
-	Latency  string `json:"latency"`
+	ID           int           `json:"id"`
+	Name         string        `json:"name"`
+	Endpoint     string        `json:"endpoint"`
+	Latency      string        `json:"latency"`
+	QPS          float64       `json:"qps,omitempty"`
+	Hedge        bool          `json:"hedge,omitempty"`
+	Retries      int           `json:"retries,omitempty"`
+	Workers      int           `json:"workers,omitempty"`
+	Queue        int           `json:"queue,omitempty"`
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+	Targeting    *Targeting    `json:"targeting,omitempty"`
+	Encoding     *Encoding     `json:"encoding,omitempty"`
+}
+
+type Size struct {
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// Capabilities mirrors the exchange DSP capabilities: media types, banner sizes and ISO-3166-1 alpha-3 countries.
+type Capabilities struct {
+	Media     []string `json:"media,omitempty"`
+	Sizes     []Size   `json:"sizes,omitempty"`
+	Countries []string `json:"countries,omitempty"`
+}
+
+// Targeting mirrors the exchange DSP targeting: publisher allow/deny lists, OS and OpenRTB device types.
+type Targeting struct {
+	Publishers        []int    `json:"publishers,omitempty"`
+	BlockedPublishers []int    `json:"blocked_publishers,omitempty"`
+	OS                []string `json:"os,omitempty"`
+	DeviceTypes       []int    `json:"device_types,omitempty"`
+}
+
+// Encoding mirrors the exchange DSP encoding: OpenRTB version, content type and compression.
+type Encoding struct {
+	Version     string `json:"version,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Compression string `json:"compression,omitempty"`
+}
+
+type profile struct {
+	capabilities *Capabilities
+	targeting    *Targeting
+}
+
+// profiles are rotated across DSPs by index. The first one places no restriction.
+var profiles = []profile{
+	{},
+	// display: banner only, common IAB sizes.
+	{capabilities: &Capabilities{
+		Media: []string{"banner"},
+		Sizes: []Size{{W: 300, H: 250}, {W: 320, H: 50}, {W: 728, H: 90}},
+	}},
+	// video: video and audio only.
+	{capabilities: &Capabilities{Media: []string{"video", "audio"}}},
+	// mobile: phones and tablets on Android and iOS.
+	{targeting: &Targeting{OS: []string{"android", "ios"}, DeviceTypes: []int{1, 4, 5}}},
+	// regional: North America only, away from a few publishers.
+	{
+		capabilities: &Capabilities{Countries: []string{"USA", "CAN", "MEX"}},
+		targeting:    &Targeting{BlockedPublishers: []int{1250, 1251, 1252}},
+	},
 }
 
 func main() {
@@ -98,6 +168,14 @@ func main() {
 	outDsps := flag.String("out-dsps", filepath.Join(cwd, "d", "dsps.json"), "output path for dsps.json")
 	latenciesPath := flag.String("latencies", filepath.Join(cwd, "d", "dsp-latencies.json"), "path to dsp-latencies.json array")
 	envPath := flag.String("env", filepath.Join(cwd, ".env"), "path to .env")
+	withProfiles := flag.Bool("profiles", false, "assign capability and targeting profiles")
+	qps := flag.Float64("qps", 0, "QPS cap of every DSP")
+	hedge := flag.Bool("hedge", false, "opt every DSP in to hedged requests")
+	retries := flag.Int("retries", 0, "retries on connection-level errors for every DSP")
+	workers := flag.Int("workers", 0, "workers of every DSP in the exchange")
+	queue := flag.Int("queue", 0, "queue size of every DSP in the exchange")
+	compression := flag.String("compression", "", "compression of the requests sent to every DSP")
+	cleartext := flag.Bool("cleartext", false, "use the cleartext http:// endpoints of the DSPs")
 	flag.Usage = usage
 	flag.Parse()
 
@@ -130,12 +208,30 @@ func main() {
 		if idx := i - 1; idx < len(latencies) && latencies[idx] != "" {
 			latency = latencies[idx]
 		}
-		dsps = append(dsps, DSPEntry{
+		endpoint := fmt.Sprintf("https://%s:%d%s", hostname, dspPort, bidPath)
+		if *cleartext {
+			endpoint = fmt.Sprintf("http://%s:%d%s", hostname, cleartextPort, bidPath)
+		}
+		entry := DSPEntry{
 			ID:       1000 + i,
 			Name:     fmt.Sprintf("dsp%d", i),
-			Endpoint: fmt.Sprintf("https://%s:%d%s", hostname, dspPort, bidPath),
+			Endpoint: endpoint,
 			Latency:  latency,
-		})
+			QPS:      *qps,
+			Hedge:    *hedge,
+			Retries:  *retries,
+			Workers:  *workers,
+			Queue:    *queue,
+		}
+		if *compression != "" {
+			entry.Encoding = &Encoding{Compression: *compression}
+		}
+		if *withProfiles {
+			p := profiles[(i-1)%len(profiles)]
+			entry.Capabilities = p.capabilities
+			entry.Targeting = p.targeting
+		}
+		dsps = append(dsps, entry)
 	}
 
 	dspsDir := filepath.Dir(*outDsps)